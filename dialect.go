@@ -0,0 +1,432 @@
+package tmeta
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dialect provides the SQL syntax that differs between databases.  Implementations for
+// SQLite3, MySQL and Postgres are included and registered by name; other databases can be
+// supported by implementing this interface (often by embedding one of the included ones and
+// overriding what is different) and calling RegisterDialect or Meta.SetDialect.
+//
+// Identifiers passed to the methods which return SQL are written as-is, callers are
+// expected to have quoted them already if needed.
+type Dialect interface {
+	// Name returns the name of the dialect, by convention the database/sql driver name.
+	Name() string
+
+	// QuoteIdent quotes a table or field name.  A dot separated name is quoted as
+	// separate parts, e.g. "table.field".
+	QuoteIdent(sqlName string) string
+
+	// InsertIgnoreSQL returns an insert statement that skips rows conflicting with an existing
+	// primary key or unique index.  valuesSQL is the list of value tuples, e.g. "(?,?),(?,?)".
+	InsertIgnoreSQL(sqlTable string, sqlFields []string, valuesSQL string) string
+
+	// UpsertSQL returns an insert statement that updates sqlUpdateFields of the existing row
+	// when one with the same sqlPKFields exists.  If sqlVersionField is not empty then the
+	// existing row is only updated if its version is one less than the inserted version, and
+	// the version is updated also.
+	UpsertSQL(sqlTable string, sqlFields []string, valuesSQL string, sqlPKFields, sqlUpdateFields []string, sqlVersionField string) string
+
+	// SupportsReturning returns true if "INSERT ... RETURNING" can be used to obtain generated IDs.
+	SupportsReturning() bool
+
+	// InsertIDs returns the IDs generated by an insert of n rows, from the result.
+	// Dialects which use RETURNING instead may return an error.
+	InsertIDs(res sql.Result, n int64) ([]int64, error)
+
+	// LimitOffsetSQL returns the clause to restrict the rows returned by a select.
+	// A negative limit means no limit and a zero or negative offset means no offset.
+	// Empty string is returned if neither applies.
+	LimitOffsetSQL(limit, offset int64) string
+
+	// ILikeSQL returns a case insensitive LIKE comparison of the field with a "?" placeholder,
+	// using the escape character '!'.
+	ILikeSQL(sqlField string) string
+
+	// FullTextSQL returns a full text search condition on the field with a "?" placeholder
+	// for the search text.  The database will usually need a full text index, or for SQLite3
+	// the table must be an FTS virtual table.
+	FullTextSQL(sqlField string) string
+
+	// SupportsRowValues returns true if row values can be compared, e.g. "(a, b) > (?, ?)".
+	SupportsRowValues() bool
+
+	// SQLType returns the column type for a field of Go type t, as used by tmetaddl.  Pointers and
+	// the sql.Null* types are treated as their underlying type, and structs which embed time.Time
+	// as time.Time.  If autoIncr is true the database should generate the value.  A type which
+	// includes "PRIMARY KEY" (as SQLite3 needs for auto increment) declares the key inline.
+	SQLType(t reflect.Type, autoIncr bool) (string, error)
+}
+
+var (
+	// verify types are compatible at compile time
+	_ Dialect = SQLite3Dialect{}
+	_ Dialect = MySQLDialect{}
+	_ Dialect = PostgresDialect{}
+)
+
+var dialectMu sync.RWMutex
+var dialectMap = map[string]Dialect{
+	SQLite3Dialect{}.Name():  SQLite3Dialect{},
+	MySQLDialect{}.Name():    MySQLDialect{},
+	PostgresDialect{}.Name(): PostgresDialect{},
+}
+
+// RegisterDialect makes a Dialect available by its Name, replacing any existing one with the same name.
+func RegisterDialect(d Dialect) {
+	dialectMu.Lock()
+	defer dialectMu.Unlock()
+	dialectMap[d.Name()] = d
+}
+
+// DialectNamed returns the registered Dialect with the given name, or nil if none.
+func DialectNamed(name string) Dialect {
+	dialectMu.RLock()
+	defer dialectMu.RUnlock()
+	return dialectMap[name]
+}
+
+// DialectNames returns the name of each registered Dialect, sorted.
+func DialectNames() []string {
+	dialectMu.RLock()
+	defer dialectMu.RUnlock()
+	ret := make([]string, 0, len(dialectMap))
+	for n := range dialectMap {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func quoteIdent(s, quote string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = quote + strings.Replace(p, quote, quote+quote, -1) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// SQLite3Dialect is the Dialect for SQLite3.
+type SQLite3Dialect struct{}
+
+func (SQLite3Dialect) Name() string { return "sqlite3" }
+
+func (SQLite3Dialect) QuoteIdent(sqlName string) string { return quoteIdent(sqlName, `"`) }
+
+func (SQLite3Dialect) InsertIgnoreSQL(sqlTable string, sqlFields []string, valuesSQL string) string {
+	return `INSERT OR IGNORE INTO ` + sqlTable + `(` + strings.Join(sqlFields, `,`) + `) VALUES ` + valuesSQL
+}
+
+func (SQLite3Dialect) UpsertSQL(sqlTable string, sqlFields []string, valuesSQL string, sqlPKFields, sqlUpdateFields []string, sqlVersionField string) string {
+	return onConflictUpsertSQL(sqlTable, sqlFields, valuesSQL, sqlPKFields, sqlUpdateFields, sqlVersionField)
+}
+
+func (SQLite3Dialect) SupportsReturning() bool { return false }
+
+// InsertIDs relies on SQLite3 giving the last ID of the batch, with the others before it.
+func (SQLite3Dialect) InsertIDs(res sql.Result, n int64) ([]int64, error) {
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return sequentialIDs(lastID-n+1, n), nil
+}
+
+func (SQLite3Dialect) LimitOffsetSQL(limit, offset int64) string {
+	if offset > 0 && limit < 0 {
+		// SQLite3 requires a LIMIT with OFFSET, -1 means no limit
+		return fmt.Sprintf("LIMIT -1 OFFSET %d", offset)
+	}
+	return limitOffsetSQL(limit, offset)
+}
+
+func (SQLite3Dialect) ILikeSQL(sqlField string) string { return lowerLikeSQL(sqlField) }
+
+func (SQLite3Dialect) FullTextSQL(sqlField string) string { return sqlField + ` MATCH ?` }
+
+// SupportsRowValues is true for SQLite3 3.15 and later.
+func (SQLite3Dialect) SupportsRowValues() bool { return true }
+
+func (SQLite3Dialect) SQLType(t reflect.Type, autoIncr bool) (string, error) {
+	t = sqlBaseType(t)
+	switch t {
+	case timeType:
+		return "TEXT", nil
+	case bytesType:
+		return "BLOB", nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "INTEGER", nil
+	case reflect.String:
+		return "TEXT", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if autoIncr {
+			// SQLite3 only allows AUTOINCREMENT on an inline INTEGER PRIMARY KEY
+			return "INTEGER PRIMARY KEY AUTOINCREMENT", nil
+		}
+		return "INTEGER", nil
+	case reflect.Float32, reflect.Float64:
+		return "REAL", nil
+	}
+	return "", noSQLTypeError(t)
+}
+
+// MySQLDialect is the Dialect for MySQL.
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string { return "mysql" }
+
+func (MySQLDialect) QuoteIdent(sqlName string) string { return quoteIdent(sqlName, "`") }
+
+func (MySQLDialect) InsertIgnoreSQL(sqlTable string, sqlFields []string, valuesSQL string) string {
+	return `INSERT IGNORE INTO ` + sqlTable + `(` + strings.Join(sqlFields, `,`) + `) VALUES ` + valuesSQL
+}
+
+func (MySQLDialect) UpsertSQL(sqlTable string, sqlFields []string, valuesSQL string, sqlPKFields, sqlUpdateFields []string, sqlVersionField string) string {
+
+	// MySQL has no WHERE for this, each assignment has to check the version
+	// itself and the version must be assigned last since assignments are
+	// evaluated in order
+	sets := make([]string, 0, len(sqlUpdateFields)+1)
+	if sqlVersionField != "" {
+		cond := sqlVersionField + ` = VALUES(` + sqlVersionField + `) - 1`
+		for _, f := range append(sqlUpdateFields[:len(sqlUpdateFields):len(sqlUpdateFields)], sqlVersionField) {
+			sets = append(sets, f+` = IF(`+cond+`, VALUES(`+f+`), `+f+`)`)
+		}
+	} else {
+		for _, f := range sqlUpdateFields {
+			sets = append(sets, f+` = VALUES(`+f+`)`)
+		}
+	}
+	if len(sets) == 0 { // no-op update, just ignore the duplicate
+		sets = append(sets, sqlPKFields[0]+` = `+sqlPKFields[0])
+	}
+
+	return `INSERT INTO ` + sqlTable + `(` + strings.Join(sqlFields, `,`) + `) VALUES ` + valuesSQL +
+		` ON DUPLICATE KEY UPDATE ` + strings.Join(sets, `, `)
+}
+
+func (MySQLDialect) SupportsReturning() bool { return false }
+
+// InsertIDs relies on MySQL giving the first ID of the batch, with the others after it.
+// This is the case unless innodb_autoinc_lock_mode is set to 2 ("interleaved").
+func (MySQLDialect) InsertIDs(res sql.Result, n int64) ([]int64, error) {
+	firstID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return sequentialIDs(firstID, n), nil
+}
+
+func (MySQLDialect) LimitOffsetSQL(limit, offset int64) string {
+	if offset > 0 && limit < 0 {
+		limit = 1<<63 - 1 // MySQL requires a LIMIT with OFFSET
+	}
+	return limitOffsetSQL(limit, offset)
+}
+
+func (MySQLDialect) ILikeSQL(sqlField string) string { return lowerLikeSQL(sqlField) }
+
+func (MySQLDialect) FullTextSQL(sqlField string) string {
+	return `MATCH(` + sqlField + `) AGAINST(? IN NATURAL LANGUAGE MODE)`
+}
+
+func (MySQLDialect) SupportsRowValues() bool { return true }
+
+func (MySQLDialect) SQLType(t reflect.Type, autoIncr bool) (string, error) {
+	t = sqlBaseType(t)
+	switch t {
+	case timeType:
+		return "DATETIME(6)", nil
+	case bytesType:
+		return "LONGBLOB", nil
+	}
+	s := ""
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.String:
+		return "VARCHAR(255)", nil
+	case reflect.Int8, reflect.Uint8:
+		s = "TINYINT"
+	case reflect.Int16, reflect.Uint16:
+		s = "SMALLINT"
+	case reflect.Int32, reflect.Uint32:
+		s = "INT"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		s = "BIGINT"
+	case reflect.Float32:
+		return "FLOAT", nil
+	case reflect.Float64:
+		return "DOUBLE", nil
+	default:
+		return "", noSQLTypeError(t)
+	}
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s += " UNSIGNED"
+	}
+	if autoIncr {
+		s += " AUTO_INCREMENT"
+	}
+	return s, nil
+}
+
+// PostgresDialect is the Dialect for Postgres.
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string { return "postgres" }
+
+func (PostgresDialect) QuoteIdent(sqlName string) string { return quoteIdent(sqlName, `"`) }
+
+func (PostgresDialect) InsertIgnoreSQL(sqlTable string, sqlFields []string, valuesSQL string) string {
+	return `INSERT INTO ` + sqlTable + `(` + strings.Join(sqlFields, `,`) + `) VALUES ` + valuesSQL + ` ON CONFLICT DO NOTHING`
+}
+
+func (PostgresDialect) UpsertSQL(sqlTable string, sqlFields []string, valuesSQL string, sqlPKFields, sqlUpdateFields []string, sqlVersionField string) string {
+	return onConflictUpsertSQL(sqlTable, sqlFields, valuesSQL, sqlPKFields, sqlUpdateFields, sqlVersionField)
+}
+
+func (PostgresDialect) SupportsReturning() bool { return true }
+
+func (PostgresDialect) InsertIDs(res sql.Result, n int64) ([]int64, error) {
+	return nil, fmt.Errorf("LastInsertId is not supported by postgres, use RETURNING")
+}
+
+func (PostgresDialect) LimitOffsetSQL(limit, offset int64) string {
+	return limitOffsetSQL(limit, offset)
+}
+
+func (PostgresDialect) ILikeSQL(sqlField string) string { return sqlField + ` ILIKE ? ESCAPE '!'` }
+
+func (PostgresDialect) FullTextSQL(sqlField string) string {
+	return `to_tsvector(` + sqlField + `) @@ plainto_tsquery(?)`
+}
+
+func (PostgresDialect) SupportsRowValues() bool { return true }
+
+func (PostgresDialect) SQLType(t reflect.Type, autoIncr bool) (string, error) {
+	t = sqlBaseType(t)
+	switch t {
+	case timeType:
+		return "TIMESTAMP", nil
+	case bytesType:
+		return "BYTEA", nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.String:
+		return "TEXT", nil
+	case reflect.Int8, reflect.Uint8, reflect.Int16:
+		return "SMALLINT", nil
+	case reflect.Uint16, reflect.Int32:
+		if autoIncr {
+			return "SERIAL", nil
+		}
+		return "INTEGER", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		if autoIncr {
+			return "BIGSERIAL", nil
+		}
+		return "BIGINT", nil
+	case reflect.Float32:
+		return "REAL", nil
+	case reflect.Float64:
+		return "DOUBLE PRECISION", nil
+	}
+	return "", noSQLTypeError(t)
+}
+
+// onConflictUpsertSQL is the "ON CONFLICT ... DO UPDATE" syntax shared by SQLite3 and Postgres
+func onConflictUpsertSQL(sqlTable string, sqlFields []string, valuesSQL string, sqlPKFields, sqlUpdateFields []string, sqlVersionField string) string {
+
+	ret := `INSERT INTO ` + sqlTable + `(` + strings.Join(sqlFields, `,`) + `) VALUES ` + valuesSQL +
+		` ON CONFLICT (` + strings.Join(sqlPKFields, `,`) + `)`
+
+	sets := make([]string, 0, len(sqlUpdateFields)+1)
+	for _, f := range sqlUpdateFields {
+		sets = append(sets, f+` = excluded.`+f)
+	}
+	if sqlVersionField != "" {
+		sets = append(sets, sqlVersionField+` = excluded.`+sqlVersionField)
+	}
+
+	if len(sets) == 0 {
+		return ret + ` DO NOTHING`
+	}
+	ret += ` DO UPDATE SET ` + strings.Join(sets, `, `)
+	if sqlVersionField != "" {
+		ret += ` WHERE ` + sqlTable + `.` + sqlVersionField + ` = excluded.` + sqlVersionField + ` - 1`
+	}
+
+	return ret
+}
+
+func lowerLikeSQL(sqlField string) string {
+	return `LOWER(` + sqlField + `) LIKE LOWER(?) ESCAPE '!'`
+}
+
+func limitOffsetSQL(limit, offset int64) string {
+	var parts []string
+	if limit >= 0 {
+		parts = append(parts, fmt.Sprintf("LIMIT %d", limit))
+	}
+	if offset > 0 {
+		parts = append(parts, fmt.Sprintf("OFFSET %d", offset))
+	}
+	return strings.Join(parts, " ")
+}
+
+var (
+	bytesType       = reflect.TypeOf([]byte(nil))
+	nullStringType  = reflect.TypeOf(sql.NullString{})
+	nullInt64Type   = reflect.TypeOf(sql.NullInt64{})
+	nullFloat64Type = reflect.TypeOf(sql.NullFloat64{})
+	nullBoolType    = reflect.TypeOf(sql.NullBool{})
+)
+
+// sqlBaseType returns the type that SQLType maps for t, see Dialect.SQLType
+func sqlBaseType(t reflect.Type) reflect.Type {
+	t = derefType(t)
+	switch t {
+	case nullStringType:
+		return reflect.TypeOf("")
+	case nullInt64Type:
+		return reflect.TypeOf(int64(0))
+	case nullFloat64Type:
+		return reflect.TypeOf(float64(0))
+	case nullBoolType:
+		return reflect.TypeOf(false)
+	}
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Type == timeType {
+				return timeType
+			}
+		}
+	}
+	return t
+}
+
+func noSQLTypeError(t reflect.Type) error {
+	return fmt.Errorf("unable to determine SQL type for Go type %v, use the sql_type tag option to specify it", t)
+}
+
+func sequentialIDs(firstID, n int64) []int64 {
+	ret := make([]int64, 0, n)
+	for i := int64(0); i < n; i++ {
+		ret = append(ret, firstID+i)
+	}
+	return ret
+}
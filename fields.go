@@ -0,0 +1,146 @@
+package tmeta
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// FieldInfo is the information for one database field (a struct field with a `db` tag other than "-").
+type FieldInfo struct {
+	GoName       string       // Go struct field name
+	SQLName      string       // SQL field name, from the db tag
+	GoType       reflect.Type // type of the Go struct field
+	Index        []int        // index path for use with reflect.Value.FieldByIndex, includes embedded structs
+	IsPK         bool         // one of the SQLPKFields
+	IsVersion    bool         // the SQLVersionField
+	IsSoftDelete bool         // the SQLSoftDeleteField
+	Nullable     bool         // GoType can hold NULL: a pointer, interface or struct with a bool Valid field (e.g. sql.NullString)
+	Options      url.Values   // parsed tmeta tag, e.g. Options.Get("sql_type")
+	DBOptions    []string     // options in the db tag after the name, e.g. "omitempty"
+}
+
+// Fields returns the database fields in the order they appear in the struct.  The returned
+// FieldInfos are shared with the TableInfo and must not be modified.
+func (ti *TableInfo) Fields() []*FieldInfo {
+	return ti.fields
+}
+
+// FieldBySQLName returns the field with the given SQL name, or nil if there is none.
+func (ti *TableInfo) FieldBySQLName(sqlName string) *FieldInfo {
+	return ti.fieldsBySQLName[sqlName]
+}
+
+// FieldByGoName returns the database field with the given Go struct field name, or nil if there is none.
+func (ti *TableInfo) FieldByGoName(goName string) *FieldInfo {
+	return ti.fieldsByGoName[goName]
+}
+
+// setFields builds the FieldInfos for the Go type, called by SetGoType
+func (ti *TableInfo) setFields() {
+
+	t := ti.goType
+	idxes := exportedFieldIndexes(t)
+
+	ti.fields = make([]*FieldInfo, 0, len(idxes))
+	ti.fieldsBySQLName = make(map[string]*FieldInfo, len(idxes))
+	ti.fieldsByGoName = make(map[string]*FieldInfo, len(idxes))
+
+	for _, idx := range idxes {
+		sf := t.FieldByIndex(idx)
+		dbParts := strings.Split(sf.Tag.Get("db"), ",")
+		if dbParts[0] == "" || dbParts[0] == "-" {
+			continue
+		}
+		fi := &FieldInfo{
+			GoName:    sf.Name,
+			SQLName:   dbParts[0],
+			GoType:    sf.Type,
+			Index:     idx,
+			Nullable:  isNullableType(sf.Type),
+			Options:   structTagToValues(sf.Tag.Get(tmetaTag)),
+			DBOptions: dbParts[1:],
+		}
+		ti.fields = append(ti.fields, fi)
+		// the first one wins if an embedded struct has a field with the same SQL name,
+		// and the shallowest one for the same Go name, like Go itself
+		if ti.fieldsBySQLName[fi.SQLName] == nil {
+			ti.fieldsBySQLName[fi.SQLName] = fi
+		}
+		if prev := ti.fieldsByGoName[fi.GoName]; prev == nil || len(prev.Index) > len(fi.Index) {
+			ti.fieldsByGoName[fi.GoName] = fi
+		}
+	}
+
+	ti.setFieldFlags()
+}
+
+// SQLValues returns the values of the fields of o (a struct or pointer to one of the type this
+// TableInfo describes) in the same order as SQLFields(withPK), e.g. for use with dbr.InsertStmt.Values.
+func (ti *TableInfo) SQLValues(o interface{}, withPK bool) []interface{} {
+	n := len(ti.fields)
+	if !withPK {
+		n = len(ti.nonPKFields)
+	}
+	return ti.AppendSQLValues(make([]interface{}, 0, n), o, withPK)
+}
+
+// AppendSQLValues is like SQLValues but appends the values to dst and returns the extended slice,
+// so the values for many records can be collected without allocating a slice for each.
+func (ti *TableInfo) AppendSQLValues(dst []interface{}, o interface{}, withPK bool) []interface{} {
+	fields := ti.fields
+	if !withPK {
+		fields = ti.nonPKFields
+	}
+	v := derefValue(reflect.ValueOf(o))
+	for _, fi := range fields {
+		dst = append(dst, fieldByIndex(v, fi.Index).Interface())
+	}
+	return dst
+}
+
+// setFieldFlags updates the IsPK, IsVersion and IsSoftDelete flags on the fields after
+// the corresponding setting on the TableInfo is changed, along with the field lists
+// precomputed from them
+func (ti *TableInfo) setFieldFlags() {
+
+	ti.sqlFieldNames = make([]string, 0, len(ti.fields))
+	ti.nonPKFields = make([]*FieldInfo, 0, len(ti.fields))
+	ti.nonPKSQLFieldNames = make([]string, 0, len(ti.fields))
+	for _, fi := range ti.fields {
+		fi.IsPK = ti.IsSQLPKField(fi.SQLName)
+		fi.IsVersion = fi.SQLName == ti.sqlVersionField
+		fi.IsSoftDelete = fi.SQLName == ti.sqlSoftDeleteField
+		ti.sqlFieldNames = append(ti.sqlFieldNames, fi.SQLName)
+		if !fi.IsPK {
+			ti.nonPKFields = append(ti.nonPKFields, fi)
+			ti.nonPKSQLFieldNames = append(ti.nonPKSQLFieldNames, fi.SQLName)
+		}
+	}
+
+	ti.pkFields = make([]*FieldInfo, 0, len(ti.sqlPKFields))
+	for _, pkf := range ti.sqlPKFields {
+		ti.pkFields = append(ti.pkFields, ti.fieldsBySQLName[pkf])
+	}
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but avoids the loop for the common case
+// of a field which is not in an embedded struct
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	if len(index) == 1 {
+		return v.Field(index[0])
+	}
+	return v.FieldByIndex(index)
+}
+
+// isNullableType returns true if a value of type t can be written as NULL
+func isNullableType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return true
+	case reflect.Struct:
+		vf, ok := t.FieldByName("Valid")
+		return ok && vf.Type.Kind() == reflect.Bool
+	}
+	return false
+}
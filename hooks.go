@@ -0,0 +1,48 @@
+package tmeta
+
+import "context"
+
+// Hook identifies when a HookFunc is called.
+type Hook string
+
+const (
+	BeforeInsert Hook = "before_insert"
+	AfterInsert  Hook = "after_insert"
+	BeforeUpdate Hook = "before_update"
+	AfterUpdate  Hook = "after_update"
+	BeforeDelete Hook = "before_delete"
+	AfterDelete  Hook = "after_delete"
+)
+
+// HookFunc is called with a pointer to a record before or after it is written.  Returning
+// an error from a "before" hook prevents the statement from being built, from an "after"
+// hook the error is returned in place of the (successful) result.
+type HookFunc func(ctx context.Context, o interface{}) error
+
+// hookKey is the key for Meta.hookMap
+type hookKey struct {
+	name string
+	hook Hook
+}
+
+// AddHook registers f to be called for records of the table with the given (logical) name,
+// in addition to any hook methods implemented by the record itself (which are called first).
+// Hooks for the same table and Hook are called in the order they were added.
+// Query builders call these, e.g. see tmetadbr.Builder.Insert.
+// Panics with ErrFrozen if the Meta is frozen.
+func (m *Meta) AddHook(name string, hook Hook, f HookFunc) {
+	m.lock()
+	defer m.mu.Unlock()
+	if m.hookMap == nil {
+		m.hookMap = make(map[hookKey][]HookFunc)
+	}
+	k := hookKey{name: name, hook: hook}
+	m.hookMap[k] = append(m.hookMap[k], f)
+}
+
+// Hooks returns the HookFuncs added with AddHook for the table name and Hook given.
+func (m *Meta) Hooks(name string, hook Hook) []HookFunc {
+	defer m.rlock()()
+	hs := m.hookMap[hookKey{name: name, hook: hook}]
+	return hs[:len(hs):len(hs)] // so appending to it can't change ours
+}
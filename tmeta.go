@@ -4,13 +4,56 @@ package tmeta
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const tmetaTag = "tmeta"
 
+var tagOptionMu sync.RWMutex
+
+// tagOptions are the options recognized in the tmeta struct tag, see RegisterTagOptions
+var tagOptions = map[string]bool{
+	// relations
+	"belongs_to": true, "has_many": true, "has_one": true, "belongs_to_many": true, "belongs_to_many_ids": true,
+	"relation_name": true, "sql_id_field": true, "sql_other_id_field": true, "join_name": true, "count_of": true,
+	// fields
+	"pk": true, "auto_incr": true, "version": true, "soft_delete": true,
+	// validation
+	"required": true, "max_len": true, "min": true, "max": true, "enum": true, "pattern": true,
+}
+
+// RegisterTagOptions adds to the options recognized in the tmeta struct tag, for packages which
+// read their own options from FieldInfo.Options (tmetadbr and tmetaddl register theirs when
+// imported).  Other options are ignored unless Meta.SetStrictTags is used.
+func RegisterTagOptions(names ...string) {
+	tagOptionMu.Lock()
+	defer tagOptionMu.Unlock()
+	for _, n := range names {
+		tagOptions[n] = true
+	}
+}
+
+// unknownTagOption returns the first option in tagv (sorted) which is not allowed, or empty string if none
+func unknownTagOption(tagv url.Values) string {
+	tagOptionMu.RLock()
+	defer tagOptionMu.RUnlock()
+	var ret string
+	for opt := range tagv {
+		if opt != "" && !tagOptions[opt] && (ret == "" || opt < ret) {
+			ret = opt
+		}
+	}
+	return ret
+}
+
 /*
 Some conventions for consistency:
 - Names of anything in Go have "Go" in them, names of anything in SQL have "SQL" in them; otherwise
@@ -35,28 +78,113 @@ func MustParse(i interface{}) {
 	DefaultMeta.MustParse(i)
 }
 
+// ErrFrozen is returned (or panicked with, by methods which don't return an error) when
+// trying to change a Meta after Freeze has been called.
+var ErrFrozen = errors.New("tmeta: Meta is frozen")
+
 // NewMeta makes a new Meta.
 func NewMeta() *Meta {
 	return &Meta{
 		tableInfoMap: make(map[reflect.Type]*TableInfo),
+		nameMap:      make(map[string]reflect.Type),
 	}
 }
 
 // Meta knows about your tables and the Go structs they correspond to.
+// It is safe for concurrent use, so tables can be registered while others are being looked up.
+// The TableInfos themselves are not locked, so set them up before passing them to SetTableInfo
+// rather than changing them afterward.  Once all tables are registered, Freeze can be called
+// to make the Meta read-only, after which lookups don't need to take the lock.
 type Meta struct {
+	mu           sync.RWMutex
+	frozen       atomic.Bool
 	tableInfoMap map[reflect.Type]*TableInfo
-	// NOTE: delay DriverName until we actually need it - a better abstraction might be some sort of Dialect
-	// DriverName   string
+	nameMap      map[string]reflect.Type // index of TableInfo.Name() to the key in tableInfoMap
+	dialect      Dialect
+	hookMap      map[hookKey][]HookFunc
+	sqlNamers    []func(name string) string // each function passed to ReplaceSQLNames, in order
+	strictTags   bool
+}
+
+// Freeze makes the Meta read-only, methods which would change it afterward return or panic
+// with ErrFrozen.  Calling it more than once has no effect.
+func (m *Meta) Freeze() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frozen.Store(true)
+}
+
+// Frozen returns true if Freeze has been called.
+func (m *Meta) Frozen() bool {
+	return m.frozen.Load()
+}
+
+// rlock takes the read lock unless the Meta is frozen (in which case nothing can change it),
+// and returns the function to release it
+func (m *Meta) rlock() func() {
+	if m.frozen.Load() {
+		return func() {}
+	}
+	m.mu.RLock()
+	return m.mu.RUnlock
+}
+
+// lock takes the write lock, or panics with ErrFrozen if the Meta is frozen
+func (m *Meta) lock() {
+	m.mu.Lock()
+	if m.frozen.Load() {
+		m.mu.Unlock()
+		panic(ErrFrozen)
+	}
+}
+
+// SetDialect sets the Dialect used when building queries for these tables.
+// Panics with ErrFrozen if the Meta is frozen.
+func (m *Meta) SetDialect(d Dialect) {
+	m.lock()
+	defer m.mu.Unlock()
+	m.dialect = d
+}
+
+// SetStrictTags makes parsing a struct with an option in its tmeta tag that is not built in or
+// registered with RegisterTagOptions an error, to catch typos and values which were split on a comma.
+// Panics with ErrFrozen if the Meta is frozen.
+func (m *Meta) SetStrictTags(strict bool) {
+	m.lock()
+	defer m.mu.Unlock()
+	m.strictTags = strict
+}
+
+// StrictTags returns the value set with SetStrictTags.
+func (m *Meta) StrictTags() bool {
+	defer m.rlock()()
+	return m.strictTags
+}
+
+// Dialect returns the Dialect set with SetDialect, or nil if not set.
+func (m *Meta) Dialect() Dialect {
+	defer m.rlock()()
+	return m.dialect
 }
 
 // TableInfo is the information for a single table.
 type TableInfo struct {
-	name            string       // the short name for this table, by convention this is often the SQLTableName but not required
-	sqlName         string       // SQL table names
-	goType          reflect.Type // underlying Go type (pointer removed)
-	sqlPKFields     []string     // SQL primary key field names
-	pkAutoIncr      bool         // true if keys are auto-incremented by the database
-	sqlVersionField string       // name of version col, empty disables optimistic locking
+	name               string             // the short name for this table, by convention this is often the SQLTableName but not required
+	sqlName            string             // SQL table names
+	goType             reflect.Type       // underlying Go type (pointer removed)
+	sqlPKFields        []string           // SQL primary key field names
+	pkAutoIncr         bool               // true if keys are auto-incremented by the database
+	sqlVersionField    string             // name of version col, empty disables optimistic locking
+	sqlSoftDeleteField string             // name of soft delete col, empty means records are really deleted
+	relationCountMap   map[string]string  // relation name -> Go field to hold the count of related records
+	validations        []*FieldValidation // checked by Validate, in field order
+	fields             []*FieldInfo       // database fields in struct order, see Fields
+	fieldsBySQLName    map[string]*FieldInfo
+	fieldsByGoName     map[string]*FieldInfo
+	sqlFieldNames      []string     // precomputed by setFieldFlags for SQLFields
+	nonPKSQLFieldNames []string     // same without the primary keys
+	nonPKFields        []*FieldInfo // fields which are not primary keys, in struct order
+	pkFields           []*FieldInfo // fields for sqlPKFields, in the same order
 	RelationMap
 
 	// TODO: function to generate new version number? (should increment for number or generate nonce for string)
@@ -73,6 +201,7 @@ func NewTableInfo(goType reflect.Type) *TableInfo {
 // if not already set.
 func (ti *TableInfo) SetGoType(goType reflect.Type) *TableInfo {
 	ti.goType = goType
+	ti.setFields()
 	if ti.name == "" {
 		n := camelToSnake(goType.Name())
 		return ti.SetName(n)
@@ -119,10 +248,8 @@ func (ti *TableInfo) SQLPKFields() []string {
 // GoPKFields returns the Go struct field name(s) of the primary key(s).
 func (ti *TableInfo) GoPKFields() []string {
 	var ret []string
-	for _, pkf := range ti.sqlPKFields {
-		idx := sqlFieldIndex(ti.GoType(), pkf)
-		sf := ti.GoType().FieldByIndex(idx)
-		ret = append(ret, sf.Name)
+	for _, fi := range ti.pkFields {
+		ret = append(ret, fi.GoName)
 	}
 	return ret
 }
@@ -138,10 +265,19 @@ func (ti *TableInfo) SQLVersionField() string {
 	return ti.sqlVersionField
 }
 
+// SQLSoftDeleteField returns the SQL field name of the soft delete field, empty string
+// if soft delete is disabled.  The field is either a bool, which is true for deleted
+// records, or a nullable time (*time.Time or a struct embedding time.Time which writes
+// the zero time as NULL, such as tmetautil.DBTime) which is NULL for records that are not deleted.
+func (ti *TableInfo) SQLSoftDeleteField() string {
+	return ti.sqlSoftDeleteField
+}
+
 // SetSQLPKFields sets the primary key fields.
 func (ti *TableInfo) SetSQLPKFields(isAutoIncr bool, sqlPKFields []string) *TableInfo {
 	ti.pkAutoIncr = isAutoIncr
 	ti.sqlPKFields = sqlPKFields
+	ti.setFieldFlags()
 	return ti
 }
 
@@ -157,9 +293,44 @@ func (ti *TableInfo) AddRelation(relation Relation) *TableInfo {
 // SetSQLVersionField sets the version field.
 func (ti *TableInfo) SetSQLVersionField(sqlVersionField string) *TableInfo {
 	ti.sqlVersionField = sqlVersionField
+	ti.setFieldFlags()
+	return ti
+}
+
+// SetSQLSoftDeleteField sets the soft delete field.
+func (ti *TableInfo) SetSQLSoftDeleteField(sqlSoftDeleteField string) *TableInfo {
+	ti.sqlSoftDeleteField = sqlSoftDeleteField
+	ti.setFieldFlags()
+	return ti
+}
+
+// SetRelationCountField sets the Go field which holds the number of records for the named
+// relation, see RelationCountField.
+func (ti *TableInfo) SetRelationCountField(relationName, goField string) *TableInfo {
+	if ti.relationCountMap == nil {
+		ti.relationCountMap = make(map[string]string)
+	}
+	ti.relationCountMap[relationName] = goField
 	return ti
 }
 
+// RelationCountField returns the name of the integer Go field which holds the number of
+// records for the named relation (set with the `tmeta:"count_of=relation_name"` struct tag),
+// or empty string if there is none.
+func (ti *TableInfo) RelationCountField(relationName string) string {
+	return ti.relationCountMap[relationName]
+}
+
+// RelationCountNames returns the names of the relations which have a count field, sorted.
+func (ti *TableInfo) RelationCountNames() []string {
+	ret := make([]string, 0, len(ti.relationCountMap))
+	for n := range ti.relationCountMap {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 // IsSQLPKField returns true if the SQL field name provided is one of the primary key fields.
 func (ti *TableInfo) IsSQLPKField(sqlName string) bool {
 	for _, f := range ti.sqlPKFields {
@@ -173,21 +344,11 @@ func (ti *TableInfo) IsSQLPKField(sqlName string) bool {
 // SQLFields returns the SQL field names for this table.  If withPK is true
 // the primary key(s) are included in the result.
 func (ti *TableInfo) SQLFields(withPK bool) []string {
-	var ret []string
-	idxes := exportedFieldIndexes(ti.goType)
-
-	for _, idx := range idxes {
-		sf := ti.goType.FieldByIndex(idx)
-		sfdb := strings.SplitN(sf.Tag.Get("db"), ",", 2)[0]
-		if sfdb == "" || sfdb == "-" {
-			continue
-		}
-		if !ti.IsSQLPKField(sfdb) || withPK {
-			ret = append(ret, sfdb)
-		}
+	// copied so callers can modify the result
+	if withPK {
+		return append([]string(nil), ti.sqlFieldNames...)
 	}
-
-	return ret
+	return append([]string(nil), ti.nonPKSQLFieldNames...)
 }
 
 // SQLFieldsExcept returns the SQL field names for this table excluding the ones you provide.
@@ -209,9 +370,15 @@ nextField:
 // SQLPKWhere returns a where clause with the primary key fields ANDed together and "?" for placeholders.
 // For example: "key1 = ? AND key2 = ?"
 func (ti *TableInfo) SQLPKWhere() string {
+	return ti.SQLPKWhereQuoted(func(s string) string { return s })
+}
+
+// SQLPKWhereQuoted is like SQLPKWhere but each field name is passed through the quote
+// function provided (e.g. Dialect.QuoteIdent).
+func (ti *TableInfo) SQLPKWhereQuoted(quote func(sqlName string) string) string {
 	var buf bytes.Buffer
 	for _, fn := range ti.SQLPKFields() {
-		fmt.Fprintf(&buf, " AND %s = ?", fn)
+		fmt.Fprintf(&buf, " AND %s = ?", quote(fn))
 	}
 	return strings.TrimPrefix(buf.String(), " AND ")
 }
@@ -220,9 +387,9 @@ func (ti *TableInfo) SQLPKWhere() string {
 // Will panic if pk fields cannot be found (e.g. if `o` is of the wrong type).
 func (ti *TableInfo) PKValues(o interface{}) []interface{} {
 	v := derefValue(reflect.ValueOf(o))
-	ret := make([]interface{}, 0, len(ti.sqlPKFields))
-	for _, sfn := range ti.sqlPKFields {
-		ret = append(ret, sqlFieldValue(v, sfn))
+	ret := make([]interface{}, len(ti.pkFields))
+	for i, fi := range ti.pkFields {
+		ret[i] = fieldByIndex(v, fi.Index).Interface()
 	}
 	return ret
 }
@@ -230,18 +397,14 @@ func (ti *TableInfo) PKValues(o interface{}) []interface{} {
 // SQLValueMap returns a map of [SQLField]->[Value] for all database fields on this struct.
 // If includePks is false then primary key fields are omitted.
 func (ti *TableInfo) SQLValueMap(o interface{}, includePks bool) map[string]interface{} {
+	fields := ti.fields
+	if !includePks {
+		fields = ti.nonPKFields
+	}
 	v := derefValue(reflect.ValueOf(o))
-	t := v.Type()
-	idxes := exportedFieldIndexes(t)
-	ret := make(map[string]interface{}, len(idxes))
-	for _, idx := range idxes {
-		sfdb := strings.SplitN(t.FieldByIndex(idx).Tag.Get("db"), ",", 2)[0]
-		if sfdb == "" || sfdb == "-" { // skip non-db-tagged fields
-			continue
-		}
-		if !ti.IsSQLPKField(sfdb) || includePks {
-			ret[sfdb] = v.FieldByIndex(idx).Interface()
-		}
+	ret := make(map[string]interface{}, len(fields))
+	for _, fi := range fields {
+		ret[fi.SQLName] = fieldByIndex(v, fi.Index).Interface()
 	}
 	return ret
 }
@@ -279,9 +442,38 @@ func (ti *TableInfo) SQLValueMap(o interface{}, includePks bool) map[string]inte
 // This will remove/overwrite the name associated with that type as well, and will also remove
 // any entry with the same name before setting.  This behavior allows overrides where a package
 // a default TableInfo can exist for a type but a specific usage requires it to be assigned differently.
+// Panics with ErrFrozen if the Meta is frozen.
 func (m *Meta) SetTableInfo(ty reflect.Type, ti *TableInfo) {
-	delete(m.tableInfoMap, m.typeForName(ti.name))
-	m.tableInfoMap[derefType(ty)] = ti
+	err := m.setTableInfo(ty, ti)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// setTableInfo does the work for SetTableInfo but returns ErrFrozen instead of panicing
+func (m *Meta) setTableInfo(ty reflect.Type, ti *TableInfo) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.frozen.Load() {
+		return ErrFrozen
+	}
+
+	ty = derefType(ty)
+
+	// remove the name this type had and the type which had this name
+	if old := m.tableInfoMap[ty]; old != nil {
+		delete(m.nameMap, old.name)
+	}
+	if oldTy, ok := m.nameMap[ti.name]; ok {
+		delete(m.tableInfoMap, oldTy)
+	}
+
+	m.tableInfoMap[ty] = ti
+	m.nameMap[ti.name] = ty
+
+	return nil
 }
 
 // For will return the TableInfo for a struct.  Pointers will be dereferenced.
@@ -294,22 +486,32 @@ func (m *Meta) For(i interface{}) *TableInfo {
 // For will return the TableInfo for a struct type.  Pointers will be dereferenced.
 // Nil will be returned if no such table exists.
 func (m *Meta) ForType(t reflect.Type) *TableInfo {
-	return m.tableInfoMap[derefType(t)]
+	t = derefType(t)
+	defer m.rlock()()
+	return m.tableInfoMap[t]
 }
 
 // ForName will return the TableInfo with the given name.
 // Nil will be returned if no such table exists.
 func (m *Meta) ForName(name string) *TableInfo {
-	return m.ForType(m.typeForName(name))
+	defer m.rlock()()
+	t, ok := m.nameMap[name]
+	if !ok {
+		return nil
+	}
+	return m.tableInfoMap[t]
 }
 
-func (m *Meta) typeForName(name string) reflect.Type {
-	for t, ti := range m.tableInfoMap {
-		if ti.name == name {
-			return t
-		}
+// Names returns the name of each table, sorted.
+func (m *Meta) Names() []string {
+	unlock := m.rlock()
+	ret := make([]string, 0, len(m.nameMap))
+	for name := range m.nameMap {
+		ret = append(ret, name)
 	}
-	return nil
+	unlock()
+	sort.Strings(ret)
+	return ret
 }
 
 // Parse will extract TableInfo data from the type of the value given (must be a properly tagged struct).
@@ -339,6 +541,8 @@ func (m *Meta) ParseTypeNamed(t reflect.Type, name string) error {
 
 	var ti TableInfo
 
+	strictTags := m.StrictTags()
+
 	ti.SetName(name)
 	ti.SetGoType(t)
 
@@ -347,6 +551,9 @@ func (m *Meta) ParseTypeNamed(t reflect.Type, name string) error {
 
 		tag := f.Tag.Get(tmetaTag)
 		tagv := structTagToValues(tag)
+		if opt := unknownTagOption(tagv); strictTags && opt != "" {
+			return fmt.Errorf("unknown tmeta tag option %q on field %q on type %v", opt, f.Name, t)
+		}
 
 		// check relations
 		if len(tagv["belongs_to"]) > 0 {
@@ -488,12 +695,32 @@ func (m *Meta) ParseTypeNamed(t reflect.Type, name string) error {
 
 		}
 
+		// record count_of fields, the relation is checked after the loop since it may come later
+		if countOf := tagv.Get("count_of"); countOf != "" {
+			switch f.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				return fmt.Errorf("count_of field %q on type %v must be an integer, not %v", f.Name, t, f.Type)
+			}
+			ti.SetRelationCountField(countOf, f.Name)
+		}
+
 		// past this point, skip fields not tagged with db
 		sqlName := strings.Split(f.Tag.Get("db"), ",")[0]
 		if sqlName == "" || sqlName == "-" {
 			continue
 		}
 
+		// check for validation options
+		fv, err := parseFieldValidation(t, f, sqlName, tagv)
+		if err != nil {
+			return err
+		}
+		if fv != nil {
+			ti.SetFieldValidation(fv)
+		}
+
 		// check for primary key
 		if len(tagv["pk"]) > 0 {
 			ti.sqlPKFields = append(ti.sqlPKFields, sqlName)
@@ -509,15 +736,33 @@ func (m *Meta) ParseTypeNamed(t reflect.Type, name string) error {
 			continue
 		}
 
+		// check for soft delete
+		if len(tagv["soft_delete"]) > 0 {
+			if !isSoftDeleteType(f.Type) {
+				return fmt.Errorf("soft_delete field %q on type %v must be a bool or time, not %v", f.Name, t, f.Type)
+			}
+			ti.sqlSoftDeleteField = sqlName
+			continue
+		}
+
 	}
 
 	if len(ti.sqlPKFields) < 1 {
 		return fmt.Errorf("no primary key fields found for type %v", t)
 	}
+	ti.setFieldFlags()
+
+	for _, rn := range ti.RelationCountNames() {
+		switch ti.RelationNamed(rn).(type) {
+		case *HasMany, *BelongsToMany, *BelongsToManyIDs:
+		case nil:
+			return fmt.Errorf("count_of field %q on type %v refers to relation %q which does not exist", ti.RelationCountField(rn), t, rn)
+		default:
+			return fmt.Errorf("count_of field %q on type %v refers to relation %q which is not has_many or belongs_to_many", ti.RelationCountField(rn), t, rn)
+		}
+	}
 
-	m.SetTableInfo(t, &ti)
-
-	return nil
+	return m.setTableInfo(t, &ti)
 
 }
 
@@ -529,14 +774,60 @@ func (m *Meta) ParseType(t reflect.Type) error {
 	return m.ParseTypeNamed(t, camelToSnake(t.Name()))
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
+// isSoftDeleteType returns true if t can be used as a soft delete field: a bool, *time.Time,
+// or a struct embedding time.Time (or a pointer to one).  A plain time.Time is not allowed
+// because the zero value is not written as NULL.
+func isSoftDeleteType(t reflect.Type) bool {
+	if t.Kind() == reflect.Bool {
+		return true
+	}
+	if t == timeType {
+		return false
+	}
+	t = derefType(t)
+	if t == timeType {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type == timeType {
+			return true
+		}
+	}
+	return false
+}
+
 // ReplaceSQLNames provides the SQLName of each table to a function and sets the
 // table name to the return value.  For example, you can easily prefix all of the
 // tables by doing:
 // m.ReplaceSQLNames(func(n string) string { return "prefix_" + n })
+// Each TableInfo is replaced with a copy with the new SQLName, so ones already obtained with For
+// (possibly by another goroutine) are not changed.  Panics with ErrFrozen if the Meta is frozen.
 func (m *Meta) ReplaceSQLNames(namer func(name string) string) {
-	for _, ti := range m.tableInfoMap {
-		ti.sqlName = namer(ti.sqlName)
+	m.lock()
+	defer m.mu.Unlock()
+	for t, ti := range m.tableInfoMap {
+		ti2 := *ti
+		ti2.sqlName = namer(ti.sqlName)
+		m.tableInfoMap[t] = &ti2
+	}
+	m.sqlNamers = append(m.sqlNamers, namer)
+}
+
+// ReplacedSQLName returns sqlName with each function passed to ReplaceSQLNames applied in order,
+// i.e. the SQLName a table with this SQL name would have if it had been registered before them.
+// This is useful for tables which are not registered but should be named the same way.
+func (m *Meta) ReplacedSQLName(sqlName string) string {
+	defer m.rlock()()
+	for _, namer := range m.sqlNamers {
+		sqlName = namer(sqlName)
 	}
+	return sqlName
 }
 
 // func (m *Meta) AddTable(i interface{}) *TableInfo {
@@ -1,7 +1,12 @@
 package tmeta
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -202,3 +207,423 @@ func TestCRUD(t *testing.T) {
 // TODO
 // auto increment ids
 // optimistic locking
+
+type testCockroachDialect struct {
+	PostgresDialect
+}
+
+func (testCockroachDialect) Name() string { return "test_cockroach" }
+
+func TestDialect(t *testing.T) {
+
+	assert := assert.New(t)
+
+	assert.Equal(`"test_widget"."order"`, DialectNamed("sqlite3").QuoteIdent("test_widget.order"))
+	assert.Equal("`user`", DialectNamed("mysql").QuoteIdent("user"))
+	assert.Equal(`"a""b"`, DialectNamed("postgres").QuoteIdent(`a"b`))
+
+	assert.Equal("LIMIT 10 OFFSET 20", SQLite3Dialect{}.LimitOffsetSQL(10, 20))
+	assert.Equal("LIMIT -1 OFFSET 20", SQLite3Dialect{}.LimitOffsetSQL(-1, 20))
+	assert.Equal("OFFSET 20", PostgresDialect{}.LimitOffsetSQL(-1, 20))
+	assert.Equal("", MySQLDialect{}.LimitOffsetSQL(-1, 0))
+	assert.True(PostgresDialect{}.SupportsRowValues())
+
+	assert.Equal(`INSERT INTO widget(widget_id,name,version) VALUES (?,?,?) ON CONFLICT (widget_id) DO UPDATE SET name = excluded.name, version = excluded.version WHERE widget.version = excluded.version - 1`,
+		PostgresDialect{}.UpsertSQL("widget", []string{"widget_id", "name", "version"}, "(?,?,?)", []string{"widget_id"}, []string{"name"}, "version"))
+	assert.Equal(`INSERT INTO widget(widget_id,name,version) VALUES (?,?,?) ON DUPLICATE KEY UPDATE name = IF(version = VALUES(version) - 1, VALUES(name), name), version = IF(version = VALUES(version) - 1, VALUES(version), version)`,
+		MySQLDialect{}.UpsertSQL("widget", []string{"widget_id", "name", "version"}, "(?,?,?)", []string{"widget_id"}, []string{"name"}, "version"))
+
+	assert.Equal(`LOWER(name) LIKE LOWER(?) ESCAPE '!'`, SQLite3Dialect{}.ILikeSQL("name"))
+	assert.Equal(`name ILIKE ? ESCAPE '!'`, PostgresDialect{}.ILikeSQL("name"))
+	assert.Equal(`name MATCH ?`, SQLite3Dialect{}.FullTextSQL("name"))
+
+	// new dialects can be registered
+	assert.Nil(DialectNamed("test_cockroach"))
+	RegisterDialect(testCockroachDialect{})
+	assert.Contains(DialectNames(), "test_cockroach")
+	assert.True(DialectNamed("test_cockroach").SupportsReturning())
+
+	meta := NewMeta()
+	assert.Nil(meta.Dialect())
+	meta.SetDialect(MySQLDialect{})
+	assert.Equal("mysql", meta.Dialect().Name())
+
+}
+
+func TestSoftDeleteParse(t *testing.T) {
+
+	assert := assert.New(t)
+
+	type Archived struct {
+		ArchivedID string     `db:"archived_id" tmeta:"pk"`
+		DeletedAt  *time.Time `db:"deleted_at" tmeta:"soft_delete"`
+	}
+	type BadArchived struct {
+		BadArchivedID string    `db:"bad_archived_id" tmeta:"pk"`
+		DeletedAt     time.Time `db:"deleted_at" tmeta:"soft_delete"`
+	}
+
+	meta := NewMeta()
+	assert.NoError(meta.Parse(Archived{}))
+	assert.Equal("deleted_at", meta.For(Archived{}).SQLSoftDeleteField())
+	assert.Equal([]string{"deleted_at"}, meta.For(Archived{}).SQLFields(false))
+
+	// zero time.Time is not NULL, so it can't be used
+	assert.Error(meta.Parse(BadArchived{}))
+
+}
+
+func TestRelationCountParse(t *testing.T) {
+
+	assert := assert.New(t)
+
+	type Shelf struct {
+		ShelfID   string `db:"shelf_id" tmeta:"pk"`
+		BookCount int    `db:"-" tmeta:"count_of=book_list"`
+		BookList  []struct {
+			BookID string `db:"book_id" tmeta:"pk"`
+		} `db:"-" tmeta:"has_many"`
+	}
+	type NoRelation struct {
+		NoRelationID string `db:"no_relation_id" tmeta:"pk"`
+		BookCount    int    `db:"-" tmeta:"count_of=book_list"`
+	}
+	type NotInt struct {
+		NotIntID  string   `db:"not_int_id" tmeta:"pk"`
+		BookCount string   `db:"-" tmeta:"count_of=book_list"`
+		BookList  []string `db:"-" tmeta:"has_many"`
+	}
+
+	meta := NewMeta()
+	assert.NoError(meta.Parse(Shelf{}))
+	assert.Equal("BookCount", meta.For(Shelf{}).RelationCountField("book_list"))
+	assert.Equal([]string{"book_list"}, meta.For(Shelf{}).RelationCountNames())
+	assert.Equal([]string{"shelf_id"}, meta.For(Shelf{}).SQLFields(true))
+
+	assert.Error(meta.Parse(NoRelation{}))
+	assert.Error(meta.Parse(NotInt{}))
+
+}
+
+func TestValidate(t *testing.T) {
+
+	assert := assert.New(t)
+
+	type Gadget struct {
+		GadgetID string  `db:"gadget_id" tmeta:"pk,required"`
+		Name     string  `db:"name" tmeta:"required,max_len=10"`
+		Color    string  `db:"color" tmeta:"enum=red|green|blue"`
+		Code     *string `db:"code" tmeta:"pattern=^[A-Z]+$"`
+		Weight   float64 `db:"weight" tmeta:"min=0.5,max=100"`
+		Notes    string  `db:"notes"`
+	}
+	type BadGadget struct {
+		BadGadgetID string `db:"bad_gadget_id" tmeta:"pk"`
+		Weight      int    `db:"weight" tmeta:"max_len=5"`
+	}
+
+	meta := NewMeta()
+	assert.NoError(meta.Parse(Gadget{}))
+	ti := meta.For(Gadget{})
+	assert.Len(ti.FieldValidations(), 5)
+
+	code := "ABC"
+	assert.NoError(ti.Validate(&Gadget{GadgetID: "gadget_0001", Name: "Sprocket", Color: "red", Code: &code, Weight: 1}))
+	// optional fields can be left empty
+	assert.NoError(ti.Validate(Gadget{GadgetID: "gadget_0001", Name: "Sprocket", Weight: 1}))
+
+	code = "abc"
+	err := ti.Validate(&Gadget{Name: "Sprocket Deluxe", Color: "pink", Code: &code, Weight: 0.1})
+	if assert.IsType(ValidationErrors{}, err) {
+		verrs := err.(ValidationErrors)
+		assert.Equal(400, verrs.Code())
+		assert.Equal(map[string]string{
+			"gadget_id": "is required",
+			"name":      "must be at most 10 characters",
+			"color":     "must be one of red, green, blue",
+			"code":      "must match the pattern ^[A-Z]+$",
+			"weight":    "must be at least 0.5",
+		}, verrs.Map())
+		assert.Equal("gadget_id", verrs[0].SQLName)
+	}
+
+	assert.Error(ti.Validate(&BadGadget{})) // wrong type
+	assert.Error(meta.Parse(BadGadget{}))
+
+	// commas inside {} and [] don't split the tag
+	type Part struct {
+		PartID string `db:"part_id" tmeta:"pk,pattern=^[a-z,]{2,5}$"`
+	}
+	assert.NoError(meta.Parse(Part{}))
+	assert.Equal("^[a-z,]{2,5}$", meta.For(Part{}).FieldValidations()[0].Pattern.String())
+
+	// unknown options are ignored unless strict tags are enabled
+	type Typo struct {
+		TypoID string `db:"typo_id" tmeta:"pk"`
+		Name   string `db:"name" tmeta:"requried"`
+	}
+	type Custom struct {
+		CustomID string `db:"custom_id" tmeta:"pk,x_custom=1"`
+	}
+	assert.NoError(meta.Parse(Typo{}))
+	assert.NoError(meta.Parse(Custom{}))
+	meta.SetStrictTags(true)
+	err = meta.Parse(Typo{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), `"requried"`)
+	}
+	assert.Error(meta.Parse(Custom{}))
+	RegisterTagOptions("x_custom")
+	assert.NoError(meta.Parse(Custom{}))
+
+}
+
+func TestFields(t *testing.T) {
+
+	assert := assert.New(t)
+
+	type Base struct {
+		ThingID string `db:"thing_id" tmeta:"pk"`
+		Name    string `db:"name"`
+	}
+	type Thing struct {
+		Base
+		Name      string     `db:"display_name,omitempty" tmeta:"sql_type=VARCHAR(100),not_null"`
+		Price     float64    `db:"price"`
+		DeletedAt *time.Time `db:"deleted_at" tmeta:"soft_delete"`
+		Version   int64      `db:"version" tmeta:"version"`
+		Notes     string     `db:"-"`
+	}
+
+	meta := NewMeta()
+	assert.NoError(meta.Parse(Thing{}))
+	ti := meta.For(Thing{})
+
+	var names []string
+	for _, fi := range ti.Fields() {
+		names = append(names, fi.SQLName)
+	}
+	assert.Equal([]string{"thing_id", "name", "display_name", "price", "deleted_at", "version"}, names)
+	assert.Equal(ti.SQLFields(true), names)
+
+	fi := ti.FieldBySQLName("thing_id")
+	assert.Equal("ThingID", fi.GoName)
+	assert.Equal([]int{0, 0}, fi.Index)
+	assert.True(fi.IsPK)
+
+	// the outer field wins by Go name
+	fi = ti.FieldByGoName("Name")
+	assert.Equal("display_name", fi.SQLName)
+	assert.Equal("VARCHAR(100)", fi.Options.Get("sql_type"))
+	assert.Equal([]string{"omitempty"}, fi.DBOptions)
+	assert.False(fi.Nullable)
+
+	fi = ti.FieldBySQLName("deleted_at")
+	assert.True(fi.IsSoftDelete)
+	assert.True(fi.Nullable)
+	assert.True(ti.FieldBySQLName("version").IsVersion)
+	assert.Nil(ti.FieldByGoName("Notes"))
+	assert.Nil(ti.FieldBySQLName("notes"))
+
+	thing := Thing{Base: Base{ThingID: "thing_0001", Name: "base"}, Name: "Thing", Price: 9.5, Version: 2}
+	assert.Equal([]interface{}{"base", "Thing", 9.5, (*time.Time)(nil), int64(2)}, ti.SQLValues(&thing, false))
+	assert.Equal([]interface{}{"x", "thing_0001"}, ti.AppendSQLValues([]interface{}{"x"}, thing, true)[:2])
+
+	// flags follow changes made with the setters
+	ti.SetSQLVersionField("")
+	assert.False(ti.FieldBySQLName("version").IsVersion)
+	ti.SetSQLPKFields(false, []string{"thing_id", "price"})
+	assert.True(ti.FieldBySQLName("price").IsPK)
+	assert.Equal([]string{"ThingID", "Price"}, ti.GoPKFields())
+
+}
+
+func TestMetaConcurrent(t *testing.T) {
+
+	assert := assert.New(t)
+
+	meta := NewMeta()
+	assert.NoError(meta.Parse(Author{}))
+
+	// register tables while others are looked up
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			// a distinct struct type for each
+			ty := reflect.StructOf([]reflect.StructField{{
+				Name: fmt.Sprintf("Plugin%dID", i),
+				Type: reflect.TypeOf(""),
+				Tag:  reflect.StructTag(fmt.Sprintf(`db:"plugin_%d_id" tmeta:"pk"`, i)),
+			}})
+			assert.NoError(meta.ParseTypeNamed(ty, fmt.Sprintf("plugin_%d", i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.NotNil(meta.For(Author{}))
+			assert.NotNil(meta.ForName("author"))
+			meta.Names()
+		}()
+	}
+	wg.Wait()
+	assert.Len(meta.Names(), 21)
+	assert.NotNil(meta.ForName("plugin_7"))
+
+	// registering a type under a new name removes the old one, and the name is looked up by index
+	assert.NoError(meta.ParseTypeNamed(reflect.TypeOf(Author{}), "writer"))
+	assert.Nil(meta.ForName("author"))
+	assert.Equal("writer", meta.For(Author{}).Name())
+
+	// ReplaceSQLNames doesn't change TableInfos already handed out
+	ti := meta.ForName("writer")
+	meta.ReplaceSQLNames(func(n string) string { return "x_" + n })
+	assert.Equal("writer", ti.SQLName())
+	assert.Equal("x_writer", meta.ForName("writer").SQLName())
+	assert.Equal("x_other", meta.ReplacedSQLName("other"))
+
+	meta.Freeze()
+	assert.True(meta.Frozen())
+	assert.Equal(ErrFrozen, meta.Parse(Book{}))
+	assert.Nil(meta.For(Book{}))
+	assert.PanicsWithValue(ErrFrozen, func() { meta.SetDialect(SQLite3Dialect{}) })
+	assert.PanicsWithValue(ErrFrozen, func() { meta.ReplaceSQLNames(func(n string) string { return n }) })
+	assert.NotNil(meta.For(Author{}))
+
+}
+
+func benchmarkMeta(b *testing.B) *TableInfo {
+	meta := NewMeta()
+	if err := meta.Parse(Book{}); err != nil {
+		b.Fatal(err)
+	}
+	return meta.For(Book{})
+}
+
+// reflectSQLFields, reflectSQLValueMap and reflectPKValues are the versions of SQLFields, SQLValueMap
+// and PKValues from before the field information was precomputed, which scanned the struct fields
+// and split the db tags on each call, kept as a baseline for the benchmarks.
+
+func reflectSQLFields(ti *TableInfo, withPK bool) []string {
+	var ret []string
+	for _, idx := range exportedFieldIndexes(ti.goType) {
+		sf := ti.goType.FieldByIndex(idx)
+		sfdb := strings.SplitN(sf.Tag.Get("db"), ",", 2)[0]
+		if sfdb == "" || sfdb == "-" {
+			continue
+		}
+		if !ti.IsSQLPKField(sfdb) || withPK {
+			ret = append(ret, sfdb)
+		}
+	}
+	return ret
+}
+
+func reflectSQLValueMap(ti *TableInfo, o interface{}, includePks bool) map[string]interface{} {
+	v := derefValue(reflect.ValueOf(o))
+	t := v.Type()
+	idxes := exportedFieldIndexes(t)
+	ret := make(map[string]interface{}, len(idxes))
+	for _, idx := range idxes {
+		sfdb := strings.SplitN(t.FieldByIndex(idx).Tag.Get("db"), ",", 2)[0]
+		if sfdb == "" || sfdb == "-" {
+			continue
+		}
+		if !ti.IsSQLPKField(sfdb) || includePks {
+			ret[sfdb] = v.FieldByIndex(idx).Interface()
+		}
+	}
+	return ret
+}
+
+type reflectFieldIndexKey struct {
+	T reflect.Type
+	F string
+}
+
+var reflectFieldIndexMU sync.RWMutex
+var reflectFieldIndexCache = make(map[reflectFieldIndexKey][]int)
+
+func reflectPKValues(ti *TableInfo, o interface{}) []interface{} {
+	v := derefValue(reflect.ValueOf(o))
+	t := v.Type()
+	ret := make([]interface{}, 0, len(ti.sqlPKFields))
+	for _, sfn := range ti.sqlPKFields {
+		key := reflectFieldIndexKey{T: t, F: sfn}
+		reflectFieldIndexMU.RLock()
+		idx, ok := reflectFieldIndexCache[key]
+		reflectFieldIndexMU.RUnlock()
+		if !ok {
+			reflectFieldIndexMU.Lock()
+			for _, i := range exportedFieldIndexes(t) {
+				if strings.SplitN(t.FieldByIndex(i).Tag.Get("db"), ",", 2)[0] == sfn {
+					idx = i
+					break
+				}
+			}
+			reflectFieldIndexCache[key] = idx
+			reflectFieldIndexMU.Unlock()
+		}
+		ret = append(ret, v.FieldByIndex(idx).Interface())
+	}
+	return ret
+}
+
+func BenchmarkSQLFields(b *testing.B) {
+	ti := benchmarkMeta(b)
+	if !reflect.DeepEqual(reflectSQLFields(ti, true), ti.SQLFields(true)) {
+		b.Fatal("SQLFields does not match the baseline")
+	}
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectSQLFields(ti, true)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ti.SQLFields(true)
+		}
+	})
+}
+
+func BenchmarkSQLValueMap(b *testing.B) {
+	ti := benchmarkMeta(b)
+	book := &Book{BookID: "book_0001", AuthorID: "author_0001", PublisherID: "publisher_0001", Title: "Les Misérables"}
+	if !reflect.DeepEqual(reflectSQLValueMap(ti, book, true), ti.SQLValueMap(book, true)) {
+		b.Fatal("SQLValueMap does not match the baseline")
+	}
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectSQLValueMap(ti, book, true)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ti.SQLValueMap(book, true)
+		}
+	})
+}
+
+func BenchmarkPKValues(b *testing.B) {
+	ti := benchmarkMeta(b)
+	book := &Book{BookID: "book_0001"}
+	if !reflect.DeepEqual(reflectPKValues(ti, book), ti.PKValues(book)) {
+		b.Fatal("PKValues does not match the baseline")
+	}
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectPKValues(ti, book)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ti.PKValues(book)
+		}
+	})
+}
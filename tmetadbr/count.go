@@ -0,0 +1,112 @@
+package tmetadbr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/gocaveman/tmeta/tmetautil"
+	"github.com/gocraft/dbr"
+)
+
+// MustCount is the same as Count but panics on error.
+func (b *Builder) MustCount(o interface{}, criteria tmetautil.Criteria) *dbr.SelectStmt {
+	ret, err := b.Count(o, criteria)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Count is like SelectCriteria but selects "COUNT(*)" instead of the fields, for use with
+// LoadOne into an integer.  Soft deleted records are not counted unless IncludeDeleted is set.
+func (b *Builder) Count(o interface{}, criteria tmetautil.Criteria) (*dbr.SelectStmt, error) {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := b.Session.
+		Select("COUNT(*)").
+		From(q(ti.SQLName()))
+
+	return b.whereCriteria(b.selectNotDeleted(stmt, ti, q, false), ti, q, criteria)
+}
+
+// MustExists is the same as Exists but panics on error.
+func (b *Builder) MustExists(o interface{}, ids ...interface{}) *dbr.SelectStmt {
+	ret, err := b.Exists(o, ids...)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Exists is like SelectByID but selects "1" with "LIMIT 1", loading it with LoadOne
+// returns dbr.ErrNotFound if the record does not exist.
+func (b *Builder) Exists(o interface{}, ids ...interface{}) (*dbr.SelectStmt, error) {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+
+	// fill ids if not provided
+	if len(ids) == 0 {
+		ids = ti.PKValues(o)
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := b.Session.
+		Select("1").
+		From(q(ti.SQLName())).
+		Where(ti.SQLPKWhereQuoted(q), ids...).
+		Limit(1)
+
+	return b.selectNotDeleted(stmt, ti, q, false), nil
+}
+
+// LoadPage executes SelectCriteria, loading the records into o (a pointer to a slice), and
+// returns the total number of records matching criteria regardless of limit and offset.
+// The count query is skipped when the total is known from the page itself, i.e. when it is
+// not full and not past the end.
+func (b *Builder) LoadPage(ctx context.Context, o interface{}, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, limit, offset int64) (total int64, err error) {
+
+	ov := reflect.ValueOf(o)
+	if ov.Kind() != reflect.Ptr || ov.Elem().Kind() != reflect.Slice {
+		return 0, fmt.Errorf("LoadPage requires a pointer to a slice, got %T", o)
+	}
+
+	stmt, err := b.SelectCriteria(o, criteria, orderBy, limit, offset)
+	if err != nil {
+		return 0, err
+	}
+	n, err := stmt.LoadContext(ctx, o)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if (limit < 0 || int64(n) < limit) && (n > 0 || offset == 0) {
+		return offset + int64(n), nil
+	}
+
+	cstmt, err := b.Count(o, criteria)
+	if err != nil {
+		return 0, err
+	}
+	err = cstmt.LoadOneContext(ctx, &total)
+	return total, err
+}
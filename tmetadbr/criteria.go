@@ -0,0 +1,91 @@
+package tmetadbr
+
+import (
+	"reflect"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocaveman/tmeta/tmetautil"
+	"github.com/gocraft/dbr"
+)
+
+// MustSelectCriteria is the same as SelectCriteria but panics on error.
+func (b *Builder) MustSelectCriteria(o interface{}, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, limit, offset int64) *dbr.SelectStmt {
+	ret, err := b.SelectCriteria(o, criteria, orderBy, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// SelectCriteria is like Select but also adds a where clause from criteria, an order by
+// clause from orderBy and a limit and offset.  Field names in criteria and orderBy are
+// checked against the SQL fields of the type, so they may come from untrusted input,
+// and quoted.  A negative limit means no limit and a zero or negative offset means no offset.
+// The limit and offset are added with Suffix using the dialect's LimitOffsetSQL, so don't
+// also call Limit or Offset on the returned statement.
+func (b *Builder) SelectCriteria(o interface{}, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, limit, offset int64) (*dbr.SelectStmt, error) {
+
+	stmt, err := b.Select(o)
+	if err != nil {
+		return nil, err
+	}
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err = b.whereCriteria(stmt, ti, q, criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	err = orderBy.CheckFieldNames(ti.SQLFields(true)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(orderBy) > 0 {
+		stmt = stmt.OrderBy(orderBy.SQLQuoted(q))
+	}
+
+	// NOTE: dbr's Limit and Offset can't express an offset without a limit, which some databases require
+	d, err := b.dialect()
+	if err != nil {
+		return nil, err
+	}
+	if s := d.LimitOffsetSQL(limit, offset); s != "" {
+		stmt = stmt.Suffix(s)
+	}
+
+	return stmt, nil
+}
+
+// whereCriteria checks the field names in criteria against the SQL fields of ti
+// and adds it as a where clause to stmt
+func (b *Builder) whereCriteria(stmt *dbr.SelectStmt, ti *tmeta.TableInfo, q func(string) string, criteria tmetautil.Criteria) (*dbr.SelectStmt, error) {
+
+	if len(criteria) == 0 {
+		return stmt, nil
+	}
+
+	err := criteria.CheckFieldNames(ti.SQLFields(true)...)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := b.dialect()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args, err := criteria.SQLDialect(d, q)
+	if err != nil {
+		return nil, err
+	}
+	if where == "" {
+		return stmt, nil
+	}
+
+	return stmt.Where(where, args...), nil
+}
@@ -0,0 +1,185 @@
+package tmetadbr
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+)
+
+// ConstraintKind describes the type of constraint violated.
+type ConstraintKind string
+
+const (
+	DuplicateKey ConstraintKind = "duplicate_key" // primary key or unique index already has the value
+	ForeignKey   ConstraintKind = "foreign_key"   // referenced record does not exist, or is still referenced
+)
+
+// ConstraintError is returned by TranslateError for constraint violations reported by the database.
+type ConstraintError struct {
+	Kind      ConstraintKind
+	SQLName   string   // SQL table name
+	SQLFields []string // SQL field names, empty if the driver does not say which
+	Err       error    // the original error from the driver
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("tmetadbr: %s violation on table %q field(s) [%s]: %v", e.Kind, e.SQLName, strings.Join(e.SQLFields, ", "), e.Err)
+}
+
+// Unwrap returns the original error from the driver.
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// Code returns 409.
+func (e *ConstraintError) Code() int { return 409 }
+
+// TranslateError converts an error from an operation on o into a more specific one, intended
+// to be used on the result of ResultWithOneUpdate or an Exec call, e.g.:
+//
+//	err = b.TranslateError(&widget, b.ResultWithOneUpdate(b.MustUpdateByID(&widget).Exec()))
+//
+// ErrUpdateFailed becomes ErrNotFound if a query by o's primary key finds no record.  If the
+// record does exist it becomes ErrVersionConflict when o has a SQLVersionField, otherwise it is
+// left as ErrUpdateFailed (e.g. MySQL does not count rows where nothing changed as affected).
+// Both satisfy errors.Is(err, ErrUpdateFailed).
+//
+// Duplicate key and foreign key errors from the SQLite3, MySQL and Postgres drivers become a
+// *ConstraintError with the table name and, where the driver provides enough information,
+// the field names from the TableInfo for o.  Other errors (and nil) are returned as-is.
+func (b *Builder) TranslateError(o interface{}, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	ti := b.Meta.For(o)
+	if ti == nil {
+		return err
+	}
+
+	if err == ErrUpdateFailed {
+		return b.updateFailedError(ti, o)
+	}
+
+	kind, sqlFields, ok := parseConstraintError(ti, err)
+	if !ok {
+		return err
+	}
+
+	return &ConstraintError{Kind: kind, SQLName: ti.SQLName(), SQLFields: sqlFields, Err: err}
+}
+
+// updateFailedError returns ErrNotFound if the record does not exist, otherwise ErrVersionConflict
+// or ErrUpdateFailed depending on if it has a version field
+func (b *Builder) updateFailedError(ti *tmeta.TableInfo, o interface{}) error {
+
+	stmt, err := b.Exists(o)
+	if err != nil {
+		return err
+	}
+
+	var one int
+	err = stmt.LoadOne(&one)
+	if err == dbr.ErrNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if ti.SQLVersionField() == "" {
+		return ErrUpdateFailed
+	}
+	return ErrVersionConflict
+}
+
+var (
+	sqliteUniqueRE  = regexp.MustCompile(`^UNIQUE constraint failed: (.*)$`)
+	mysqlErrorRE    = regexp.MustCompile(`^Error (\d+)(?: \(\w+\))?: `)
+	mysqlDupKeyRE   = regexp.MustCompile(`for key '(?:[^']*\.)?([^'.]*)'$`)
+	mysqlFKFieldsRE = regexp.MustCompile("FOREIGN KEY \\(([^)]*)\\)")
+	pqKeyRE         = regexp.MustCompile(`^Key \(([^)]*)\)=`)
+)
+
+// pqError is implemented by *pq.Error, so we don't need to import the driver
+type pqError interface {
+	Get(k byte) string
+}
+
+// parseConstraintError figures out from the driver error if it's a constraint violation
+// and which fields were involved, ok is false if it is not a constraint violation.
+func parseConstraintError(ti *tmeta.TableInfo, err error) (kind ConstraintKind, sqlFields []string, ok bool) {
+
+	msg := err.Error()
+
+	// Postgres, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+	var pqe pqError
+	if errors.As(err, &pqe) {
+		switch pqe.Get('C') {
+		case "23505":
+			kind = DuplicateKey
+		case "23503":
+			kind = ForeignKey
+		default:
+			return "", nil, false
+		}
+		if m := pqKeyRE.FindStringSubmatch(pqe.Get('D')); m != nil {
+			sqlFields = tableFields(ti, strings.Split(m[1], ","))
+		}
+		return kind, sqlFields, true
+	}
+
+	// MySQL, see https://dev.mysql.com/doc/refman/8.0/en/server-error-reference.html
+	if m := mysqlErrorRE.FindStringSubmatch(msg); m != nil {
+		switch m[1] {
+		case "1062":
+			kind = DuplicateKey
+			if km := mysqlDupKeyRE.FindStringSubmatch(msg); km != nil {
+				if km[1] == "PRIMARY" {
+					sqlFields = ti.SQLPKFields()
+				} else { // single field unique indexes are by default named after the field
+					sqlFields = tableFields(ti, []string{km[1]})
+				}
+			}
+		case "1451", "1452":
+			kind = ForeignKey
+			if fm := mysqlFKFieldsRE.FindStringSubmatch(msg); fm != nil {
+				sqlFields = tableFields(ti, strings.Split(fm[1], ","))
+			}
+		default:
+			return "", nil, false
+		}
+		return kind, sqlFields, true
+	}
+
+	// SQLite3
+	if m := sqliteUniqueRE.FindStringSubmatch(msg); m != nil {
+		return DuplicateKey, tableFields(ti, strings.Split(m[1], ",")), true
+	}
+	if msg == "FOREIGN KEY constraint failed" { // SQLite3 does not say which
+		return ForeignKey, nil, true
+	}
+
+	return "", nil, false
+}
+
+// tableFields cleans up the field names from an error message (removing whitespace, quotes
+// and table name prefixes) and returns those which are fields of ti.
+func tableFields(ti *tmeta.TableInfo, names []string) []string {
+	var ret []string
+	for _, n := range names {
+		n = strings.Trim(strings.TrimSpace(n), "`\"")
+		if i := strings.LastIndex(n, "."); i >= 0 {
+			n = strings.Trim(n[i+1:], "`\"")
+		}
+		for _, f := range ti.SQLFields(true) {
+			if f == n {
+				ret = append(ret, f)
+				break
+			}
+		}
+	}
+	return ret
+}
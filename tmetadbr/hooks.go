@@ -0,0 +1,254 @@
+package tmetadbr
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/gocaveman/tmeta"
+)
+
+// BeforeInserter can be implemented by objects to check or modify them before an insert, see Insert.
+// Returning an error prevents the insert.
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+// BeforeInsertContexter is the same as BeforeInserter but is passed the Builder's context.
+type BeforeInsertContexter interface {
+	BeforeInsertContext(ctx context.Context) error
+}
+
+// AfterInserter can be implemented by objects to be notified after a successful insert,
+// see AfterInsertHooks.
+type AfterInserter interface {
+	AfterInsert() error
+}
+
+// AfterInsertContexter is the same as AfterInserter but is passed the Builder's context.
+type AfterInsertContexter interface {
+	AfterInsertContext(ctx context.Context) error
+}
+
+// BeforeUpdater can be implemented by objects to check or modify them before an update,
+// see UpdateByID.  Returning an error prevents the update.
+type BeforeUpdater interface {
+	BeforeUpdate() error
+}
+
+// BeforeUpdateContexter is the same as BeforeUpdater but is passed the Builder's context.
+type BeforeUpdateContexter interface {
+	BeforeUpdateContext(ctx context.Context) error
+}
+
+// AfterUpdater can be implemented by objects to be notified after a successful update,
+// see AfterUpdateHooks.
+type AfterUpdater interface {
+	AfterUpdate() error
+}
+
+// AfterUpdateContexter is the same as AfterUpdater but is passed the Builder's context.
+type AfterUpdateContexter interface {
+	AfterUpdateContext(ctx context.Context) error
+}
+
+// BeforeDeleter can be implemented by objects to check them before a delete, see DeleteByID.
+// Returning an error prevents the delete.
+type BeforeDeleter interface {
+	BeforeDelete() error
+}
+
+// BeforeDeleteContexter is the same as BeforeDeleter but is passed the Builder's context.
+type BeforeDeleteContexter interface {
+	BeforeDeleteContext(ctx context.Context) error
+}
+
+// AfterDeleter can be implemented by objects to be notified after a successful delete,
+// see AfterDeleteHooks.
+type AfterDeleter interface {
+	AfterDelete() error
+}
+
+// AfterDeleteContexter is the same as AfterDeleter but is passed the Builder's context.
+type AfterDeleteContexter interface {
+	AfterDeleteContext(ctx context.Context) error
+}
+
+// WithContext returns a copy of the Builder which passes ctx to hooks (see BeforeInsertContexter
+// and tmeta.Meta.AddHook).  Without it hooks get context.Background().
+func (b *Builder) WithContext(ctx context.Context) *Builder {
+	ret := *b
+	ret.ctx = ctx
+	return &ret
+}
+
+// context returns the context for hooks
+func (b *Builder) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// AfterInsertHooks calls the AfterInsert hooks for o (a record or slice of records) if err is nil,
+// intended to be used on the result of an insert, e.g.:
+//
+//	err = b.AfterInsertHooks(&widget, b.ExecOK(b.MustInsert(&widget)))
+//
+// InsertAndLoadIDs and ResultWithInsertID call the hooks themselves.
+func (b *Builder) AfterInsertHooks(o interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	return b.runHooks(o, tmeta.AfterInsert)
+}
+
+// AfterUpdateHooks calls the AfterUpdate hooks for o if err is nil, intended to be used on the
+// result of an update, e.g.:
+//
+//	err = b.AfterUpdateHooks(&widget, b.ResultWithOneUpdate(b.MustUpdateByID(&widget).Exec()))
+func (b *Builder) AfterUpdateHooks(o interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	return b.runHooks(o, tmeta.AfterUpdate)
+}
+
+// AfterDeleteHooks calls the AfterDelete hooks for o if err is nil, intended to be used on the
+// result of a delete, e.g.:
+//
+//	err = b.AfterDeleteHooks(&widget, b.ResultWithOneUpdate(b.MustDeleteByID(&widget).Exec()))
+func (b *Builder) AfterDeleteHooks(o interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	return b.runHooks(o, tmeta.AfterDelete)
+}
+
+// runHooks calls the hook methods implemented by each record in o (a record or slice of records)
+// followed by the hooks added to the Meta for its table, stopping at the first error.
+func (b *Builder) runHooks(o interface{}, hook tmeta.Hook) error {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return ErrTypeNotRegistered
+	}
+
+	for _, el := range hookElems(o) {
+		err := b.runElemHooks(ti, el, hook)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runElemHooks calls the hook method implemented by el (a struct pointer) followed by
+// the hooks added to the Meta for ti
+func (b *Builder) runElemHooks(ti *tmeta.TableInfo, el interface{}, hook tmeta.Hook) error {
+
+	ctx := b.context()
+
+	err := callHookMethod(ctx, el, hook)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range b.Meta.Hooks(ti.Name(), hook) {
+		err := f(ctx, el)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hookElems returns a pointer to each record in o, which may be a slice of structs or struct pointers
+// (or a pointer to one) or a single struct or struct pointer.  Non-pointer structs are copied so
+// methods with pointer receivers can be called, changes to them are not seen by the caller.
+func hookElems(o interface{}) []interface{} {
+
+	v := reflect.ValueOf(o)
+	dv := derefValue(v)
+
+	if dv.Kind() != reflect.Slice {
+		return []interface{}{hookPtr(o)}
+	}
+
+	ret := make([]interface{}, 0, dv.Len())
+	for i := 0; i < dv.Len(); i++ {
+		elv := dv.Index(i)
+		switch {
+		case elv.Kind() == reflect.Ptr && elv.IsNil():
+		case elv.Kind() == reflect.Ptr:
+			ret = append(ret, elv.Interface())
+		case elv.CanAddr():
+			ret = append(ret, elv.Addr().Interface())
+		default:
+			ret = append(ret, copyPtr(elv.Interface()))
+		}
+	}
+	return ret
+}
+
+// hookPtr returns o if it is a pointer, otherwise a pointer to a copy of it
+func hookPtr(o interface{}) interface{} {
+	if reflect.TypeOf(o).Kind() == reflect.Ptr {
+		return o
+	}
+	return copyPtr(o)
+}
+
+// callHookMethod calls the method for hook if o implements it, both the plain
+// and context variants are called if both are implemented.
+func callHookMethod(ctx context.Context, o interface{}, hook tmeta.Hook) error {
+
+	var err error
+	switch hook {
+	case tmeta.BeforeInsert:
+		if h, ok := o.(BeforeInserter); ok && err == nil {
+			err = h.BeforeInsert()
+		}
+		if h, ok := o.(BeforeInsertContexter); ok && err == nil {
+			err = h.BeforeInsertContext(ctx)
+		}
+	case tmeta.AfterInsert:
+		if h, ok := o.(AfterInserter); ok && err == nil {
+			err = h.AfterInsert()
+		}
+		if h, ok := o.(AfterInsertContexter); ok && err == nil {
+			err = h.AfterInsertContext(ctx)
+		}
+	case tmeta.BeforeUpdate:
+		if h, ok := o.(BeforeUpdater); ok && err == nil {
+			err = h.BeforeUpdate()
+		}
+		if h, ok := o.(BeforeUpdateContexter); ok && err == nil {
+			err = h.BeforeUpdateContext(ctx)
+		}
+	case tmeta.AfterUpdate:
+		if h, ok := o.(AfterUpdater); ok && err == nil {
+			err = h.AfterUpdate()
+		}
+		if h, ok := o.(AfterUpdateContexter); ok && err == nil {
+			err = h.AfterUpdateContext(ctx)
+		}
+	case tmeta.BeforeDelete:
+		if h, ok := o.(BeforeDeleter); ok && err == nil {
+			err = h.BeforeDelete()
+		}
+		if h, ok := o.(BeforeDeleteContexter); ok && err == nil {
+			err = h.BeforeDeleteContext(ctx)
+		}
+	case tmeta.AfterDelete:
+		if h, ok := o.(AfterDeleter); ok && err == nil {
+			err = h.AfterDelete()
+		}
+		if h, ok := o.(AfterDeleteContexter); ok && err == nil {
+			err = h.AfterDeleteContext(ctx)
+		}
+	}
+
+	return err
+}
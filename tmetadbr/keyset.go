@@ -0,0 +1,252 @@
+package tmetadbr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocaveman/tmeta/tmetautil"
+	"github.com/gocraft/dbr"
+)
+
+// KeysetOrderBy returns orderBy with any primary key fields of ti which are not already
+// in it appended (ascending), so that the order is unique and can be used for keyset pagination.
+func KeysetOrderBy(ti *tmeta.TableInfo, orderBy tmetautil.OrderByList) tmetautil.OrderByList {
+	ret := make(tmetautil.OrderByList, 0, len(orderBy)+len(ti.SQLPKFields()))
+	ret = append(ret, orderBy...)
+pkLoop:
+	for _, pk := range ti.SQLPKFields() {
+		for _, ob := range orderBy {
+			if ob.Field == pk {
+				continue pkLoop
+			}
+		}
+		ret = append(ret, tmetautil.OrderBy{Field: pk})
+	}
+	return ret
+}
+
+// CursorCodec encodes and decodes the opaque cursors used for keyset pagination, see SelectKeyset.
+// A cursor holds the values of the sort fields (see KeysetOrderBy) of the last record of a page,
+// signed with HMAC-SHA256 so it cannot be altered or used with a different table or sort.
+// Key should be at least 32 random bytes and kept secret.
+type CursorCodec struct {
+	Key []byte
+}
+
+// NewCursorCodec returns a new CursorCodec with the key provided.
+func NewCursorCodec(key []byte) *CursorCodec {
+	return &CursorCodec{Key: key}
+}
+
+// Encode returns a cursor for the position after o, a record of the type for ti, in the
+// order given by orderBy.
+func (c *CursorCodec) Encode(ti *tmeta.TableInfo, orderBy tmetautil.OrderByList, o interface{}) (string, error) {
+
+	if derefType(reflect.TypeOf(o)) != ti.GoType() {
+		return "", fmt.Errorf("CursorCodec.Encode requires a %v, got %T", ti.GoType(), o)
+	}
+
+	keyset := KeysetOrderBy(ti, orderBy)
+	err := keyset.CheckFieldNames(ti.SQLFields(true)...)
+	if err != nil {
+		return "", err
+	}
+
+	vmap := ti.SQLValueMap(o, true)
+	vals := make([]interface{}, 0, len(keyset))
+	for _, ob := range keyset {
+		vals = append(vals, vmap[ob.Field])
+	}
+
+	payload, err := json.Marshal(vals)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(ti, keyset, payload)), nil
+}
+
+// Decode checks the signature of a cursor made by Encode and returns its values, converted to
+// the types of the corresponding fields, for use with SelectKeyset or KeysetWhere.  ti and orderBy
+// must be the same as used with Encode, otherwise ErrInvalidCursor is returned.  An empty
+// cursor returns nil values, meaning the first page.
+func (c *CursorCodec) Decode(ti *tmeta.TableInfo, orderBy tmetautil.OrderByList, cursor string) ([]interface{}, error) {
+
+	if cursor == "" {
+		return nil, nil
+	}
+
+	keyset := KeysetOrderBy(ti, orderBy)
+	err := keyset.CheckFieldNames(ti.SQLFields(true)...)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := base64.RawURLEncoding
+	parts := strings.SplitN(cursor, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if !hmac.Equal(sig, c.sign(ti, keyset, payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var raws []json.RawMessage
+	err = json.Unmarshal(payload, &raws)
+	if err != nil || len(raws) != len(keyset) {
+		return nil, ErrInvalidCursor
+	}
+
+	ret := make([]interface{}, 0, len(keyset))
+	for i, ob := range keyset {
+		v := reflect.New(sqlFieldType(ti, ob.Field))
+		err = json.Unmarshal(raws[i], v.Interface())
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		ret = append(ret, v.Elem().Interface())
+	}
+
+	return ret, nil
+}
+
+// sign returns the signature of the payload for the table and keyset
+func (c *CursorCodec) sign(ti *tmeta.TableInfo, keyset tmetautil.OrderByList, payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(ti.SQLName() + "\x00" + keyset.SQL() + "\x00"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// MustSelectKeyset is the same as SelectKeyset but panics on error.
+func (b *Builder) MustSelectKeyset(o interface{}, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, after []interface{}, limit int64) *dbr.SelectStmt {
+	ret, err := b.SelectKeyset(o, criteria, orderBy, after, limit)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// SelectKeyset is like SelectCriteria but pages using the values of the last record of the previous
+// page instead of an offset, which stays fast on large tables.  The records are sorted by
+// KeysetOrderBy(orderBy), so ties are broken by primary key.  after are the values from
+// CursorCodec.Decode, or nil for the first page.  Use CursorCodec.Encode with the last record
+// returned to make the cursor for the next page.  The sort fields should not be NULL.
+func (b *Builder) SelectKeyset(o interface{}, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, after []interface{}, limit int64) (*dbr.SelectStmt, error) {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+
+	keyset := KeysetOrderBy(ti, orderBy)
+	stmt, err := b.SelectCriteria(o, criteria, keyset, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if after == nil {
+		return stmt, nil
+	}
+
+	where, args, err := b.KeysetWhere(o, keyset, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt.Where(where, args...), nil
+}
+
+// KeysetWhere returns a where clause matching the records which come after the values provided
+// when sorted by orderBy, with orderBy being the complete sort (see KeysetOrderBy) and after
+// having one value per field in it.  If all fields have the same direction and the dialect
+// supports row values this is e.g. "(a, b) > (?, ?)", otherwise it is expanded, e.g.
+// "(a < ? OR (a = ? AND b > ?))" for "a DESC, b ASC".
+func (b *Builder) KeysetWhere(o interface{}, orderBy tmetautil.OrderByList, after []interface{}) (string, []interface{}, error) {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return "", nil, ErrTypeNotRegistered
+	}
+
+	if len(orderBy) == 0 {
+		return "", nil, fmt.Errorf("KeysetWhere requires at least one field to sort by")
+	}
+	if len(after) != len(orderBy) {
+		return "", nil, fmt.Errorf("KeysetWhere requires one value per sort field, got %d values for %d fields", len(after), len(orderBy))
+	}
+	err := orderBy.CheckFieldNames(ti.SQLFields(true)...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return "", nil, err
+	}
+	d, err := b.dialect()
+	if err != nil {
+		return "", nil, err
+	}
+
+	cmp := func(ob tmetautil.OrderBy) string {
+		if ob.Desc {
+			return " < "
+		}
+		return " > "
+	}
+
+	sameDir := true
+	for _, ob := range orderBy {
+		sameDir = sameDir && ob.Desc == orderBy[0].Desc
+	}
+
+	if len(orderBy) == 1 {
+		return q(orderBy[0].Field) + cmp(orderBy[0]) + "?", after, nil
+	}
+
+	if sameDir && d.SupportsRowValues() {
+		fields := make([]string, 0, len(orderBy))
+		marks := make([]string, 0, len(orderBy))
+		for _, ob := range orderBy {
+			fields = append(fields, q(ob.Field))
+			marks = append(marks, "?")
+		}
+		return "(" + strings.Join(fields, ", ") + ")" + cmp(orderBy[0]) + "(" + strings.Join(marks, ", ") + ")", after, nil
+	}
+
+	// a > ? OR (a = ? AND b > ?) OR (a = ? AND b = ? AND c > ?) ...
+	var terms []string
+	var args []interface{}
+	for i, ob := range orderBy {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, q(orderBy[j].Field)+" = ?")
+			args = append(args, after[j])
+		}
+		parts = append(parts, q(ob.Field)+cmp(ob)+"?")
+		args = append(args, after[i])
+		term := strings.Join(parts, " AND ")
+		if i > 0 {
+			term = "(" + term + ")"
+		}
+		terms = append(terms, term)
+	}
+
+	return "(" + strings.Join(terms, " OR ") + ")", args, nil
+}
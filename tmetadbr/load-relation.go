@@ -0,0 +1,423 @@
+package tmetadbr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+)
+
+// LoadRelation loads the named relation for every element of the slice provided, using one
+// query per relation (with an `IN (...)` clause) instead of one query per element.  The
+// results are matched back up with each element by ID and assigned to the relation's
+// GoValueField.  The slice may contain structs or struct pointers and may itself be passed
+// as a pointer.  A single struct pointer is also accepted and treated as a slice of one.
+// Any existing value in the relation field of each element is replaced.
+// Soft deleted records are not loaded unless IncludeDeleted is set, as with SelectRelationPtr.
+//
+// The relation name may also be a dot separated path of relation names, in which case
+// each relation is loaded in turn on the records loaded by the previous one, e.g.
+// "book_list.category_list" on a slice of authors will load all of the books for
+// the authors and then all of the categories for those books (one query for each).
+func (b *Builder) LoadRelation(ctx context.Context, o interface{}, relationName string) error {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return ErrTypeNotRegistered
+	}
+
+	// check the whole path before running any queries
+	relationNames := strings.Split(relationName, ".")
+	pathTI := ti
+	for i, rn := range relationNames {
+		targetTI, err := b.relationTargetTableInfo(pathTI, rn)
+		if err != nil {
+			return err
+		}
+		if targetTI == nil && i < len(relationNames)-1 {
+			return fmt.Errorf("relation %q in path %q does not load records and cannot have further relations", rn, relationName)
+		}
+		pathTI = targetTI
+	}
+
+	elems, err := structElems(o)
+	if err != nil {
+		return err
+	}
+
+	for i, rn := range relationNames {
+		err := b.loadRelation(ctx, ti, elems, rn)
+		if err != nil {
+			return err
+		}
+		if i < len(relationNames)-1 {
+			elems = relationElems(elems, ti.RelationNamed(rn).RelationGoValueField())
+			ti, _ = b.relationTargetTableInfo(ti, rn)
+		}
+	}
+
+	return nil
+}
+
+// relationTargetTableInfo returns the TableInfo for the records loaded by a relation.
+// A nil TableInfo with a nil error is returned for relations which do not load
+// records (BelongsToManyIDs).
+func (b *Builder) relationTargetTableInfo(ti *tmeta.TableInfo, relationName string) (*tmeta.TableInfo, error) {
+
+	rel := ti.RelationNamed(relationName)
+	if rel == nil {
+		return nil, fmt.Errorf("relation %q not found", relationName)
+	}
+
+	if _, ok := rel.(*tmeta.BelongsToManyIDs); ok {
+		return nil, nil
+	}
+
+	sf, ok := ti.GoType().FieldByName(rel.RelationGoValueField())
+	if !ok {
+		return nil, fmt.Errorf("relation %q refers to Go field %q which does not exist on %v", relationName, rel.RelationGoValueField(), ti.GoType())
+	}
+
+	targetTI := b.Meta.ForType(elemDerefType(sf.Type))
+	if targetTI == nil {
+		return nil, fmt.Errorf("%v is not registered", sf.Type)
+	}
+
+	return targetTI, nil
+}
+
+// loadRelation does the work for LoadRelation, elems must be addressable struct values of the type ti describes.
+func (b *Builder) loadRelation(ctx context.Context, ti *tmeta.TableInfo, elems []reflect.Value, relationName string) error {
+
+	rel := ti.RelationNamed(relationName)
+	if rel == nil {
+		return fmt.Errorf("relation %q not found", relationName)
+	}
+
+	if len(elems) == 0 {
+		return nil
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return err
+	}
+
+	fieldType, ok := ti.GoType().FieldByName(rel.RelationGoValueField())
+	if !ok {
+		return fmt.Errorf("relation %q refers to Go field %q which does not exist on %v", relationName, rel.RelationGoValueField(), ti.GoType())
+	}
+
+	switch r := rel.(type) {
+
+	case *tmeta.BelongsTo:
+		targetTI := b.Meta.ForType(derefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+		targetPKField := targetTI.SQLPKFields()[0]
+
+		ids := uniqueSQLFieldValues(elems, r.SQLIDField)
+		if len(ids) == 0 {
+			setFieldsZero(elems, r.GoValueField)
+			return nil
+		}
+
+		// the first field is used as the map key
+		mt, err := idMapType(sqlFieldType(targetTI, targetPKField), fieldType.Type)
+		if err != nil {
+			return err
+		}
+		m := reflect.New(mt)
+		stmt := b.Session.
+			Select(quoteList(q, append([]string{targetPKField}, targetTI.SQLFields(true)...))...).
+			From(q(targetTI.SQLName())).
+			Where(q(targetPKField)+" IN ?", ids)
+		_, err = b.selectNotDeleted(stmt, targetTI, q, false).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range elems {
+			setFieldFromMap(elem.FieldByName(r.GoValueField), m.Elem(), sqlFieldValue(elem, r.SQLIDField))
+		}
+		return nil
+
+	case *tmeta.HasMany:
+		targetTI := b.Meta.ForType(elemDerefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+		pkField := ti.SQLPKFields()[0]
+
+		ids := uniqueSQLFieldValues(elems, pkField)
+		if len(ids) == 0 {
+			setFieldsZero(elems, r.GoValueField)
+			return nil
+		}
+
+		// map of slices, dbr will group the rows by the first field
+		mt, err := idMapType(sqlFieldType(ti, pkField), fieldType.Type)
+		if err != nil {
+			return err
+		}
+		m := reflect.New(mt)
+		stmt := b.Session.
+			Select(quoteList(q, append([]string{r.SQLOtherIDField}, targetTI.SQLFields(true)...))...).
+			From(q(targetTI.SQLName())).
+			Where(q(r.SQLOtherIDField)+" IN ?", ids)
+		_, err = b.selectNotDeleted(stmt, targetTI, q, false).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range elems {
+			setFieldFromMap(elem.FieldByName(r.GoValueField), m.Elem(), sqlFieldValue(elem, pkField))
+		}
+		return nil
+
+	case *tmeta.HasOne:
+		targetTI := b.Meta.ForType(derefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+		pkField := ti.SQLPKFields()[0]
+
+		ids := uniqueSQLFieldValues(elems, pkField)
+		if len(ids) == 0 {
+			setFieldsZero(elems, r.GoValueField)
+			return nil
+		}
+
+		mt, err := idMapType(sqlFieldType(ti, pkField), fieldType.Type)
+		if err != nil {
+			return err
+		}
+		m := reflect.New(mt)
+		stmt := b.Session.
+			Select(quoteList(q, append([]string{r.SQLOtherIDField}, targetTI.SQLFields(true)...))...).
+			From(q(targetTI.SQLName())).
+			Where(q(r.SQLOtherIDField)+" IN ?", ids)
+		_, err = b.selectNotDeleted(stmt, targetTI, q, false).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range elems {
+			setFieldFromMap(elem.FieldByName(r.GoValueField), m.Elem(), sqlFieldValue(elem, pkField))
+		}
+		return nil
+
+	case *tmeta.BelongsToMany:
+		joinTI := b.Meta.ForName(r.JoinName)
+		if joinTI == nil {
+			return fmt.Errorf("join table %q is not registered", r.JoinName)
+		}
+		targetTI := b.Meta.ForType(elemDerefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+		pkField := ti.SQLPKFields()[0]
+
+		ids := uniqueSQLFieldValues(elems, pkField)
+		if len(ids) == 0 {
+			setFieldsZero(elems, r.GoValueField)
+			return nil
+		}
+
+		mt, err := idMapType(sqlFieldType(ti, pkField), fieldType.Type)
+		if err != nil {
+			return err
+		}
+		m := reflect.New(mt)
+		stmt := b.Session.
+			Select(quoteList(q, append(
+				[]string{joinTI.SQLName() + "." + r.SQLIDField},
+				stringsAddPrefix(targetTI.SQLFields(true), targetTI.SQLName()+".")...,
+			))...).
+			From(q(joinTI.SQLName())).
+			Join(targetTI.SQLName(),
+				fmt.Sprintf(`%s = %s`,
+					q(joinTI.SQLName()+"."+r.SQLOtherIDField),
+					q(targetTI.SQLName()+"."+targetTI.SQLPKFields()[0]),
+				)).
+			Where(q(joinTI.SQLName()+"."+r.SQLIDField)+" IN ?", ids)
+		stmt.Dialect = b.stmtDialect(stmt.Dialect)
+		_, err = b.selectNotDeleted(stmt, targetTI, q, true).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range elems {
+			setFieldFromMap(elem.FieldByName(r.GoValueField), m.Elem(), sqlFieldValue(elem, pkField))
+		}
+		return nil
+
+	case *tmeta.BelongsToManyIDs:
+		joinTI := b.Meta.ForName(r.JoinName)
+		if joinTI == nil {
+			return fmt.Errorf("join table %q is not registered", r.JoinName)
+		}
+		pkField := ti.SQLPKFields()[0]
+
+		ids := uniqueSQLFieldValues(elems, pkField)
+		if len(ids) == 0 {
+			setFieldsZero(elems, r.GoValueField)
+			return nil
+		}
+
+		mt, err := idMapType(sqlFieldType(ti, pkField), fieldType.Type)
+		if err != nil {
+			return err
+		}
+		m := reflect.New(mt)
+		_, err = b.Session.
+			Select(q(r.SQLIDField), q(r.SQLOtherIDField)).
+			From(q(joinTI.SQLName())).
+			Where(q(r.SQLIDField)+" IN ?", ids).
+			LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range elems {
+			setFieldFromMap(elem.FieldByName(r.GoValueField), m.Elem(), sqlFieldValue(elem, pkField))
+		}
+		return nil
+
+	}
+
+	return fmt.Errorf("relation %q is not of a suppported type", relationName)
+}
+
+// structElems returns the addressable struct values for a slice (or pointer to slice)
+// of structs or struct pointers, or for a single struct pointer.  Nil pointers are skipped.
+func structElems(o interface{}) ([]reflect.Value, error) {
+
+	v := derefValue(reflect.ValueOf(o))
+
+	if v.Kind() != reflect.Slice {
+		if v.Kind() != reflect.Struct || !v.CanAddr() {
+			return nil, fmt.Errorf("expected a slice or struct pointer, got %T", o)
+		}
+		return []reflect.Value{v}, nil
+	}
+
+	ret := make([]reflect.Value, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elv := v.Index(i)
+		if elv.Kind() == reflect.Ptr && elv.IsNil() {
+			continue
+		}
+		ret = append(ret, derefValue(elv))
+	}
+	return ret, nil
+}
+
+// relationElems returns the addressable struct values found in the given Go field of each
+// of elems.  The field may be a struct, struct pointer or slice of either.  Records which are
+// shared between elements (e.g. the same pointer from a BelongsTo) are only returned once.
+func relationElems(elems []reflect.Value, goFieldName string) []reflect.Value {
+
+	seen := make(map[uintptr]bool, len(elems))
+	ret := make([]reflect.Value, 0, len(elems))
+
+	add := func(v reflect.Value) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return
+		}
+		v = derefValue(v)
+		if seen[v.Addr().Pointer()] {
+			return
+		}
+		seen[v.Addr().Pointer()] = true
+		ret = append(ret, v)
+	}
+
+	for _, elem := range elems {
+		f := elem.FieldByName(goFieldName)
+		if f.Kind() == reflect.Slice {
+			for i := 0; i < f.Len(); i++ {
+				add(f.Index(i))
+			}
+			continue
+		}
+		add(f)
+	}
+
+	return ret
+}
+
+// uniqueSQLFieldValues returns the distinct non-zero values of the given SQL field across elems.
+func uniqueSQLFieldValues(elems []reflect.Value, sqlFieldName string) []interface{} {
+	seen := make(map[interface{}]bool, len(elems))
+	ret := make([]interface{}, 0, len(elems))
+	for _, elem := range elems {
+		id := sqlFieldValue(elem, sqlFieldName)
+		if id == nil || isZero(id) {
+			continue
+		}
+		key := idKey(id)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// idKey returns a value which can be used as a map key in place of id, which is id itself
+// unless its type can't be a map key (e.g. []byte)
+func idKey(id interface{}) interface{} {
+	if b, ok := id.([]byte); ok {
+		return string(b)
+	}
+	if !reflect.TypeOf(id).Comparable() {
+		return fmt.Sprint(id)
+	}
+	return id
+}
+
+// idMapType returns the type of a map from IDs of type keyType to elemType, for dbr to load the
+// related records into.  []byte IDs use string keys, which setFieldFromMap converts to.
+func idMapType(keyType, elemType reflect.Type) (reflect.Type, error) {
+	if keyType.Kind() == reflect.Slice && keyType.Elem().Kind() == reflect.Uint8 {
+		keyType = reflect.TypeOf("")
+	}
+	if !keyType.Comparable() {
+		return nil, fmt.Errorf("relations cannot be loaded by ID of type %v", keyType)
+	}
+	return reflect.MapOf(keyType, elemType), nil
+}
+
+// sqlFieldType returns the Go type of the struct field for the SQL field name given.
+func sqlFieldType(ti *tmeta.TableInfo, sqlFieldName string) reflect.Type {
+	return ti.FieldBySQLName(sqlFieldName).GoType
+}
+
+// setFieldFromMap assigns the value in m for key to field, or the zero value if
+// there is no such entry (or the key cannot be converted to the map's key type).
+func setFieldFromMap(field, m reflect.Value, key interface{}) {
+	kt := m.Type().Key()
+	kv := reflect.ValueOf(key)
+	if key == nil || !kv.Type().ConvertibleTo(kt) {
+		field.Set(reflect.Zero(field.Type()))
+		return
+	}
+	v := m.MapIndex(kv.Convert(kt))
+	if !v.IsValid() {
+		field.Set(reflect.Zero(field.Type()))
+		return
+	}
+	field.Set(v)
+}
+
+func setFieldsZero(elems []reflect.Value, goFieldName string) {
+	for _, elem := range elems {
+		f := elem.FieldByName(goFieldName)
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
@@ -0,0 +1,143 @@
+package tmetadbr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+)
+
+// mergeRetries is how many times UpdateMerge will merge and retry before giving up,
+// in case the record keeps being changed by others
+const mergeRetries = 3
+
+// ConflictError is returned by UpdateMerge when the same fields were changed both by
+// the caller and by someone else since the record was read.
+type ConflictError struct {
+	SQLName   string      // SQL table name
+	SQLFields []string    // SQL field names changed by both, sorted
+	Current   interface{} // pointer to the record as it is in the database now
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("tmetadbr: update conflict on table %q field(s): %s", e.SQLName, strings.Join(e.SQLFields, ", "))
+}
+
+// Code returns 409, the same as ErrUpdateFailed.
+func (e *ConflictError) Code() int { return 409 }
+
+// UpdateMerge updates the fields which are different between base (a copy of the record
+// as it was read) and o, as with UpdateChanged, and executes the statement.  If the update
+// fails due to the version field having changed, the current record is read with SelectByID
+// and a three-way merge is done for each field: fields changed only by someone else are taken
+// from the current record, fields changed only in o are kept.  If no field was changed both
+// ways (to different values) the update is retried with the merged record, otherwise a
+// *ConflictError is returned listing the fields.  o is only modified if the update succeeds,
+// in which case it holds the merged record with the new version.
+//
+// If the type has no SQLVersionField, conflicts cannot be detected and this is the same as
+// executing UpdateChanged.  ErrNotFound is returned if the record does not exist and
+// ErrVersionConflict if it keeps changing while trying to merge.  The BeforeUpdate hooks are
+// called (on a copy of o) for each attempt and the AfterUpdate hooks on o after it succeeds.
+func (b *Builder) UpdateMerge(ctx context.Context, base, o interface{}) error {
+
+	b = b.WithContext(ctx)
+
+	ti := b.Meta.For(o)
+	if ti == nil {
+		return ErrTypeNotRegistered
+	}
+	if derefType(reflect.TypeOf(base)) != ti.GoType() {
+		return fmt.Errorf("UpdateMerge requires base and o to be the same type, got %T and %T", base, o)
+	}
+
+	ov := derefValue(reflect.ValueOf(o))
+	if !ov.CanSet() {
+		return fmt.Errorf("UpdateMerge requires a pointer, got %T", o)
+	}
+
+	// work on copies so o is only modified on success
+	baseP := copyPtr(base)
+	oursP := copyPtr(o)
+
+	for i := 0; i <= mergeRetries; i++ {
+
+		attemptP := copyPtr(oursP)
+		stmt, err := b.UpdateChanged(baseP, attemptP)
+		if err != nil {
+			return err
+		}
+		if stmt == nil { // nothing (left) to change
+			ov.Set(reflect.ValueOf(attemptP).Elem())
+			return nil
+		}
+
+		res, err := stmt.ExecContext(ctx)
+		err = b.ResultWithOneUpdate(res, err)
+		if err == nil {
+			ov.Set(reflect.ValueOf(attemptP).Elem())
+			return b.AfterUpdateHooks(o, nil)
+		}
+		if err != ErrUpdateFailed || ti.SQLVersionField() == "" {
+			return b.TranslateError(o, err)
+		}
+
+		// find out what changed in the mean time
+		theirsP := reflect.New(ti.GoType()).Interface()
+		sstmt, err := b.SelectByID(theirsP, ti.PKValues(o)...)
+		if err != nil {
+			return err
+		}
+		err = sstmt.LoadOneContext(ctx, theirsP)
+		if err == dbr.ErrNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		mergedP, conflicts := mergeSQLFields(ti, baseP, oursP, theirsP)
+		if len(conflicts) > 0 {
+			return &ConflictError{SQLName: ti.SQLName(), SQLFields: conflicts, Current: theirsP}
+		}
+
+		baseP, oursP = theirsP, mergedP
+	}
+
+	return ErrVersionConflict
+}
+
+// mergeSQLFields does a three-way merge, returning a copy of theirs with the fields changed
+// between base and ours applied, and the sorted names of any fields changed by both
+// (to different values).  The version field is taken from theirs.
+func mergeSQLFields(ti *tmeta.TableInfo, base, ours, theirs interface{}) (merged interface{}, conflicts []string) {
+
+	baseMap := ti.SQLValueMap(base, false)
+	oursMap := ti.SQLValueMap(ours, false)
+	theirsMap := ti.SQLValueMap(theirs, false)
+
+	merged = copyPtr(theirs)
+	mv := reflect.ValueOf(merged).Elem()
+	ov := derefValue(reflect.ValueOf(ours))
+
+	for _, f := range changedSQLFields(baseMap, oursMap, ti.SQLVersionField()) {
+		if !reflect.DeepEqual(baseMap[f], theirsMap[f]) && !reflect.DeepEqual(oursMap[f], theirsMap[f]) {
+			conflicts = append(conflicts, f)
+			continue
+		}
+		idx := ti.FieldBySQLName(f).Index
+		mv.FieldByIndex(idx).Set(ov.FieldByIndex(idx))
+	}
+
+	return merged, conflicts
+}
+
+// copyPtr returns a pointer to a new copy of the struct (or struct pointer) provided
+func copyPtr(o interface{}) interface{} {
+	v := derefValue(reflect.ValueOf(o))
+	ret := reflect.New(v.Type())
+	ret.Elem().Set(v)
+	return ret.Interface()
+}
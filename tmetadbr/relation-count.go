@@ -0,0 +1,162 @@
+package tmetadbr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/gocaveman/tmeta"
+)
+
+// LoadRelationCount sets the count field of every element of the slice provided (see
+// tmeta.TableInfo.RelationCountField) to the number of records in each of the named relations,
+// using one grouped query per relation instead of one count query per element.  If no relation
+// names are given, all relations with a count field are loaded.  Only HasMany, BelongsToMany
+// and BelongsToManyIDs relations can be counted.  o is handled the same as with LoadRelation
+// and soft deleted records are not counted unless IncludeDeleted is set.
+func (b *Builder) LoadRelationCount(ctx context.Context, o interface{}, relationNames ...string) error {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return ErrTypeNotRegistered
+	}
+
+	if len(relationNames) == 0 {
+		relationNames = ti.RelationCountNames()
+	}
+
+	// check all names before running any queries
+	for _, rn := range relationNames {
+		if ti.RelationCountField(rn) == "" {
+			return fmt.Errorf("relation %q has no count field on %v", rn, ti.GoType())
+		}
+	}
+
+	elems, err := structElems(o)
+	if err != nil {
+		return err
+	}
+
+	for _, rn := range relationNames {
+		err := b.loadRelationCount(ctx, ti, elems, rn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRelationCount does the work for LoadRelationCount, elems must be addressable struct values of the type ti describes.
+func (b *Builder) loadRelationCount(ctx context.Context, ti *tmeta.TableInfo, elems []reflect.Value, relationName string) error {
+
+	rel := ti.RelationNamed(relationName)
+	if rel == nil {
+		return fmt.Errorf("relation %q not found", relationName)
+	}
+
+	countField := ti.RelationCountField(relationName)
+	countType, ok := ti.GoType().FieldByName(countField)
+	if !ok {
+		return fmt.Errorf("count field %q for relation %q does not exist on %v", countField, relationName, ti.GoType())
+	}
+
+	pkField := ti.SQLPKFields()[0]
+	ids := uniqueSQLFieldValues(elems, pkField)
+	if len(ids) == 0 {
+		setFieldsZero(elems, countField)
+		return nil
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return err
+	}
+
+	// map of parent ID to count, dbr uses the first field as the map key
+	mt, err := idMapType(sqlFieldType(ti, pkField), countType.Type)
+	if err != nil {
+		return err
+	}
+	m := reflect.New(mt)
+
+	switch r := rel.(type) {
+
+	case *tmeta.HasMany:
+		fieldType, ok := ti.GoType().FieldByName(r.GoValueField)
+		if !ok {
+			return fmt.Errorf("relation %q refers to Go field %q which does not exist on %v", relationName, r.GoValueField, ti.GoType())
+		}
+		targetTI := b.Meta.ForType(elemDerefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+
+		stmt := b.Session.
+			Select(q(r.SQLOtherIDField), "COUNT(*)").
+			From(q(targetTI.SQLName())).
+			Where(q(r.SQLOtherIDField)+" IN ?", ids).
+			GroupBy(q(r.SQLOtherIDField))
+		_, err := b.selectNotDeleted(stmt, targetTI, q, false).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+	case *tmeta.BelongsToMany:
+		joinTI := b.Meta.ForName(r.JoinName)
+		if joinTI == nil {
+			return fmt.Errorf("join table %q is not registered", r.JoinName)
+		}
+		fieldType, ok := ti.GoType().FieldByName(r.GoValueField)
+		if !ok {
+			return fmt.Errorf("relation %q refers to Go field %q which does not exist on %v", relationName, r.GoValueField, ti.GoType())
+		}
+		targetTI := b.Meta.ForType(elemDerefType(fieldType.Type))
+		if targetTI == nil {
+			return fmt.Errorf("%v is not registered", fieldType.Type)
+		}
+
+		// join with the target table so records which no longer exist (or are soft deleted) aren't counted
+		idField := q(joinTI.SQLName() + "." + r.SQLIDField)
+		stmt := b.Session.
+			Select(idField, "COUNT(*)").
+			From(q(joinTI.SQLName())).
+			Join(targetTI.SQLName(),
+				fmt.Sprintf(`%s = %s`,
+					q(joinTI.SQLName()+"."+r.SQLOtherIDField),
+					q(targetTI.SQLName()+"."+targetTI.SQLPKFields()[0]),
+				)).
+			Where(idField+" IN ?", ids).
+			GroupBy(idField)
+		stmt.Dialect = b.stmtDialect(stmt.Dialect)
+		_, err := b.selectNotDeleted(stmt, targetTI, q, true).LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+	case *tmeta.BelongsToManyIDs:
+		joinTI := b.Meta.ForName(r.JoinName)
+		if joinTI == nil {
+			return fmt.Errorf("join table %q is not registered", r.JoinName)
+		}
+
+		_, err := b.Session.
+			Select(q(r.SQLIDField), "COUNT(*)").
+			From(q(joinTI.SQLName())).
+			Where(q(r.SQLIDField)+" IN ?", ids).
+			GroupBy(q(r.SQLIDField)).
+			LoadContext(ctx, m.Interface())
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("relation %q cannot be counted, only has_many and belongs_to_many relations are supported", relationName)
+	}
+
+	for _, elem := range elems {
+		setFieldFromMap(elem.FieldByName(countField), m.Elem(), sqlFieldValue(elem, pkField))
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package tmetadbr
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/gocaveman/tmeta/tmetautil"
+	"github.com/gocraft/dbr"
+)
+
+// Repo provides the usual data access methods for one type, T, which must be
+// a struct registered with the Builder's Meta.  It is a thin wrapper around
+// the Builder methods which executes the statements and translates errors
+// (see TranslateError), so the "Store" for simple tables does not have to be
+// written by hand.  Like a Builder, a Repo is cheap to make and it is normal
+// to make one per transaction.
+type Repo[T any] struct {
+	*Builder
+}
+
+// NewRepo returns a new Repo using the Builder provided.
+func NewRepo[T any](b *Builder) *Repo[T] {
+	return &Repo[T]{Builder: b}
+}
+
+// Get returns the record with the primary key value(s) provided.
+// ErrNotFound is returned if there is no such record.
+func (r *Repo[T]) Get(ctx context.Context, ids ...interface{}) (*T, error) {
+
+	o := new(T)
+	stmt, err := r.SelectByID(o, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stmt.LoadOneContext(ctx, o)
+	if err == dbr.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// List returns the records matching criteria, sorted by orderBy, see SelectCriteria.
+func (r *Repo[T]) List(ctx context.Context, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, limit, offset int64) ([]T, error) {
+
+	var ret []T
+	stmt, err := r.SelectCriteria(&ret, criteria, orderBy, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = stmt.LoadContext(ctx, &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// ListPage is like List but also returns the total number of records matching criteria, see LoadPage.
+func (r *Repo[T]) ListPage(ctx context.Context, criteria tmetautil.Criteria, orderBy tmetautil.OrderByList, limit, offset int64) ([]T, int64, error) {
+
+	var ret []T
+	total, err := r.LoadPage(ctx, &ret, criteria, orderBy, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ret, total, nil
+}
+
+// Count returns the number of records matching criteria.
+func (r *Repo[T]) Count(ctx context.Context, criteria tmetautil.Criteria) (int64, error) {
+
+	stmt, err := r.Builder.Count(new(T), criteria)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err = stmt.LoadOneContext(ctx, &n)
+	return n, err
+}
+
+// Exists returns true if there is a record with the primary key value(s) provided.
+func (r *Repo[T]) Exists(ctx context.Context, ids ...interface{}) (bool, error) {
+
+	stmt, err := r.Builder.Exists(new(T), ids...)
+	if err != nil {
+		return false, err
+	}
+
+	var one int
+	err = stmt.LoadOneContext(ctx, &one)
+	if err == dbr.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Create inserts the record, populating an auto increment primary key and calling the insert
+// hooks (see InsertAndLoadIDs).
+func (r *Repo[T]) Create(ctx context.Context, o *T) error {
+	return r.TranslateError(o, r.InsertAndLoadIDs(ctx, o))
+}
+
+// Update updates the record by its primary key (see UpdateByID), calling the update hooks.
+func (r *Repo[T]) Update(ctx context.Context, o *T) error {
+	b := r.WithContext(ctx)
+	stmt, err := b.UpdateByID(o)
+	if err != nil {
+		return err
+	}
+	return b.TranslateError(o, b.AfterUpdateHooks(o, b.ResultWithOneUpdate(stmt.ExecContext(ctx))))
+}
+
+// Delete deletes the record by its primary key (see DeleteByID), calling the delete hooks.
+// Records with a soft delete field are marked deleted instead (see SoftDeleteByID).
+func (r *Repo[T]) Delete(ctx context.Context, o *T) error {
+	b := r.WithContext(ctx)
+	var stmt interface {
+		ExecContext(ctx context.Context) (sql.Result, error)
+	}
+	var err error
+	if ti := r.Meta.For(o); ti != nil && ti.SQLSoftDeleteField() != "" {
+		stmt, err = b.SoftDeleteByID(o)
+	} else {
+		stmt, err = b.DeleteByID(o)
+	}
+	if err != nil {
+		return err
+	}
+	return b.TranslateError(o, b.AfterDeleteHooks(o, b.ResultWithOneUpdate(stmt.ExecContext(ctx))))
+}
@@ -0,0 +1,134 @@
+package tmetadbr
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// notDeletedWhere returns a where clause and args which exclude soft deleted records
+// from ti's table, or empty string if ti has no soft delete field or IncludeDeleted is set.
+// If qualify is true the field name is prefixed with the table name.
+func (b *Builder) notDeletedWhere(ti *tmeta.TableInfo, q func(string) string, qualify bool) (string, []interface{}) {
+
+	sqlField := ti.SQLSoftDeleteField()
+	if sqlField == "" || b.IncludeDeleted {
+		return "", nil
+	}
+
+	if qualify {
+		sqlField = ti.SQLName() + "." + sqlField
+	}
+
+	if sqlFieldType(ti, ti.SQLSoftDeleteField()).Kind() == reflect.Bool {
+		return q(sqlField) + " = ?", []interface{}{false}
+	}
+	return q(sqlField) + " IS NULL", nil
+}
+
+// selectNotDeleted adds the notDeletedWhere clause for ti to stmt, if there is one.
+func (b *Builder) selectNotDeleted(stmt *dbr.SelectStmt, ti *tmeta.TableInfo, q func(string) string, qualify bool) *dbr.SelectStmt {
+	if where, args := b.notDeletedWhere(ti, q, qualify); where != "" {
+		return stmt.Where(where, args...)
+	}
+	return stmt
+}
+
+// softDeleteValue returns the value to set the soft delete field of ti to in order to mark a record deleted,
+// true for a bool and the current time otherwise.
+func softDeleteValue(ti *tmeta.TableInfo) (interface{}, error) {
+
+	t := sqlFieldType(ti, ti.SQLSoftDeleteField())
+	if t.Kind() == reflect.Bool {
+		return true, nil
+	}
+
+	// time.Time or a struct embedding it, possibly behind a pointer
+	v := reflect.New(derefType(t)).Elem()
+	now := reflect.ValueOf(time.Now())
+	if v.Type() == timeType {
+		v.Set(now)
+	} else if tf := v.FieldByName("Time"); tf.IsValid() && tf.Type() == timeType {
+		tf.Set(now)
+	} else {
+		return nil, fmt.Errorf("soft delete field %q on %v must be a bool or time, not %v", ti.SQLSoftDeleteField(), ti.GoType(), t)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return v.Addr().Interface(), nil
+	}
+	return v.Interface(), nil
+}
+
+// MustSoftDeleteByID is the same as SoftDeleteByID but panics on error.
+func (b *Builder) MustSoftDeleteByID(o interface{}, ids ...interface{}) *dbr.UpdateStmt {
+	ret, err := b.SoftDeleteByID(o, ids...)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// SoftDeleteByID makes an update statement which marks a record deleted by setting its soft
+// delete field (see TableInfo.SQLSoftDeleteField) to true or the current time.  The primary key
+// and version are used the same as with DeleteByID, and records already marked deleted are not
+// matched unless IncludeDeleted is set.  Since it is an ordinary update statement, further Where
+// calls on it are ANDed with the rest of the where clause.
+//
+// The BeforeDelete hooks are called with o (see BeforeDeleter and tmeta.Meta.AddHook),
+// note that if ids are provided o may not have its primary key set.
+func (b *Builder) SoftDeleteByID(o interface{}, ids ...interface{}) (*dbr.UpdateStmt, error) {
+
+	ti := b.Meta.For(o)
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+
+	if ti.SQLSoftDeleteField() == "" {
+		return nil, fmt.Errorf("SoftDeleteByID requires a soft delete field, %v does not have one", ti.GoType())
+	}
+
+	err := b.runHooks(o, tmeta.BeforeDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := softDeleteValue(ti)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := b.Session.
+		Update(ti.SQLName()).
+		Set(ti.SQLSoftDeleteField(), val)
+	stmt.Dialect = b.stmtDialect(stmt.Dialect)
+
+	// fill ids if not provided
+	if len(ids) == 0 {
+		ids = ti.PKValues(o)
+		// check for version field and add to where clause
+		if ti.SQLVersionField() != "" {
+			stmt = stmt.Where(q(ti.SQLVersionField())+" = ?",
+				sqlFieldValue(derefValue(reflect.ValueOf(o)), ti.SQLVersionField()))
+		}
+	}
+
+	// main where clause by ID(s)
+	stmt = stmt.Where(ti.SQLPKWhereQuoted(q), ids...)
+
+	if where, args := b.notDeletedWhere(ti, q, false); where != "" {
+		stmt = stmt.Where(where, args...)
+	}
+
+	return stmt, nil
+}
@@ -21,6 +21,18 @@ var (
 
 	// ErrUpdateFailed is used to indicate the record could not be found or an optimistic locking update failure (version changed since last read)
 	ErrUpdateFailed = &errorWithCode{code: 409, msg: "tmetadbr: update failed (not found or version conflict)"}
+
+	// ErrNotFound is used to indicate the record could not be found, see TranslateError and Repo.Get.
+	// errors.Is(ErrNotFound, ErrUpdateFailed) is true.
+	ErrNotFound = &errorWithCode{code: 404, msg: "tmetadbr: record not found", is: ErrUpdateFailed}
+
+	// ErrVersionConflict is used to indicate an optimistic locking update failure (version changed since last read),
+	// see TranslateError.  errors.Is(ErrVersionConflict, ErrUpdateFailed) is true.
+	ErrVersionConflict = &errorWithCode{code: 409, msg: "tmetadbr: version conflict (record changed since last read)", is: ErrUpdateFailed}
+
+	// ErrInvalidCursor is returned by CursorCodec.Decode when a cursor is malformed, has been
+	// altered or was made for a different table or sort.
+	ErrInvalidCursor = &errorWithCode{code: 400, msg: "tmetadbr: invalid cursor"}
 )
 
 // // IDGenerator is a function that can take an object and create IDs for the
@@ -61,20 +73,81 @@ func New(sess Session, meta *tmeta.Meta) *Builder {
 
 // Builder is used to do query building.
 // It has a Session (can be a *dbr.Session or *dbr.Tx) and *tmeta.Meta.
+// The Dialect is used for database-specific SQL, if not set the Meta's
+// Dialect is used and if that is not set either it is determined from the Session.
 type Builder struct {
 	Session Session
 	*tmeta.Meta
+	Dialect tmeta.Dialect
+	// DisableQuoting turns off quoting of table and field names in generated SQL, for
+	// cases where SQL names are raw expressions or are already quoted.  This includes the
+	// names dbr quotes itself in the statements returned (e.g. by Insert and UpdateByID).
+	DisableQuoting bool
+	// IncludeDeleted makes selects return soft deleted records (see TableInfo.SQLSoftDeleteField)
+	// and SoftDeleteByID mark records deleted even if they already are.
+	IncludeDeleted bool
 	// IDGenerator IDGenerator
+
+	ctx context.Context // passed to hooks, see WithContext
+}
+
+// quoter returns a function which quotes table and field names using the Dialect,
+// or leaves them as-is if DisableQuoting is set
+func (b *Builder) quoter() (func(sqlName string) string, error) {
+	if b.DisableQuoting {
+		return func(s string) string { return s }, nil
+	}
+	d, err := b.dialect()
+	if err != nil {
+		return nil, err
+	}
+	return d.QuoteIdent, nil
+}
+
+// noQuoteDialect is a dbr.Dialect which leaves names as-is, see Builder.DisableQuoting
+type noQuoteDialect struct {
+	dbr.Dialect
+}
+
+func (noQuoteDialect) QuoteIdent(id string) string { return id }
+
+// stmtDialect returns the dialect to set on a dbr statement which quotes table and
+// field names itself (InsertInto, Update, DeleteFrom and Join), so DisableQuoting applies there too
+func (b *Builder) stmtDialect(d dbr.Dialect) dbr.Dialect {
+	if b.DisableQuoting && d != nil {
+		return noQuoteDialect{d}
+	}
+	return d
 }
 
-// hack this dialect detection for now, would be nicer to have something more
-// formal but this is workable for the time being
-func (b *Builder) dbrDialect() dbr.Dialect {
-	f := derefValue(reflect.ValueOf(b.Session)).FieldByName("Dialect")
-	if !f.IsValid() {
-		panic("unable to find Dialect field on Session")
+// dialect returns the Dialect to use, see Builder
+func (b *Builder) dialect() (tmeta.Dialect, error) {
+
+	if b.Dialect != nil {
+		return b.Dialect, nil
+	}
+	if d := b.Meta.Dialect(); d != nil {
+		return d, nil
+	}
+
+	var dd dbr.Dialect
+	switch s := b.Session.(type) {
+	case *dbr.Session:
+		dd = s.Dialect
+	case *dbr.Tx:
+		dd = s.Dialect
 	}
-	return f.Interface().(dbr.Dialect)
+
+	switch dd {
+	case dialect.SQLite3:
+		return tmeta.SQLite3Dialect{}, nil
+	case dialect.MySQL:
+		return tmeta.MySQLDialect{}, nil
+	case dialect.PostgreSQL:
+		return tmeta.PostgresDialect{}, nil
+	}
+
+	return nil, fmt.Errorf("tmetadbr: unable to determine dialect for session %T, set Builder.Dialect or call Meta.SetDialect", b.Session)
 }
 
 // MustSelect is the same as Select but panics on error.
@@ -88,7 +161,7 @@ func (b *Builder) MustSelect(o interface{}) *dbr.SelectStmt {
 
 // Select will build a select statement with the field list of the type provided
 // from the appropriate table. If a slice is provided, the table is derived from
-// the slice's element type.
+// the slice's element type.  Soft deleted records are excluded unless IncludeDeleted is set.
 func (b *Builder) Select(o interface{}) (*dbr.SelectStmt, error) {
 
 	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
@@ -96,10 +169,16 @@ func (b *Builder) Select(o interface{}) (*dbr.SelectStmt, error) {
 		return nil, ErrTypeNotRegistered
 	}
 
-	return b.Session.
-			Select(ti.SQLFields(true)...).
-			From(ti.SQLName()),
-		nil
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := b.Session.
+		Select(quoteList(q, ti.SQLFields(true))...).
+		From(q(ti.SQLName()))
+
+	return b.selectNotDeleted(stmt, ti, q, false), nil
 }
 
 // MustSelectByID is the same as SelectByID but panics on error.
@@ -114,6 +193,7 @@ func (b *Builder) MustSelectByID(o interface{}, ids ...interface{}) *dbr.SelectS
 // SelectByID will build a select statement on the appropriate table with a where
 // clause matching the given primary keys.  If ids is non-zero len it will be used
 // as the pk values otherwise the pk values will be extracted from the object provided.
+// Soft deleted records are excluded unless IncludeDeleted is set.
 func (b *Builder) SelectByID(o interface{}, ids ...interface{}) (*dbr.SelectStmt, error) {
 
 	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
@@ -126,11 +206,17 @@ func (b *Builder) SelectByID(o interface{}, ids ...interface{}) (*dbr.SelectStmt
 		ids = ti.PKValues(o)
 	}
 
-	return b.Session.
-			Select(ti.SQLFields(true)...).
-			From(ti.SQLName()).
-			Where(ti.SQLPKWhere(), ids...),
-		nil
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := b.Session.
+		Select(quoteList(q, ti.SQLFields(true))...).
+		From(q(ti.SQLName())).
+		Where(ti.SQLPKWhereQuoted(q), ids...)
+
+	return b.selectNotDeleted(stmt, ti, q, false), nil
 }
 
 // MustInsert is the same as Insert but panics on error.
@@ -143,7 +229,9 @@ func (b *Builder) MustInsert(o interface{}) *dbr.InsertStmt {
 }
 
 // Insert generates an insert statement for the object(s) provided.  Slice is supported.
-// It also calls CreateTimeTouch on the object(s) if possible.
+// It also calls CreateTimeTouch on the object(s) if possible, followed by the BeforeInsert
+// hooks (see BeforeInserter and tmeta.Meta.AddHook).  Each record is then checked with
+// tmeta.TableInfo.Validate and tmeta.ValidationErrors is returned for the first invalid one.
 func (b *Builder) Insert(o interface{}) (*dbr.InsertStmt, error) {
 
 	// NOTE: We don't bother with the version field here, making the initial record
@@ -158,8 +246,10 @@ func (b *Builder) Insert(o interface{}) (*dbr.InsertStmt, error) {
 	stmt := b.Session.
 		InsertInto(ti.SQLName()).
 		Columns(ti.SQLFields(!ti.PKAutoIncr())...)
+	stmt.Dialect = b.stmtDialect(stmt.Dialect)
 
 	ov := derefValue(reflect.ValueOf(o))
+	recordID := usesRecordID(ti)
 
 	if ov.Kind() == reflect.Slice { // multiple records
 		for i := 0; i < ov.Len(); i++ {
@@ -182,7 +272,16 @@ func (b *Builder) Insert(o interface{}) (*dbr.InsertStmt, error) {
 			if ctt, ok := el.(UpdateTimeToucher); ok {
 				ctt.UpdateTimeTouch()
 			}
-			stmt = stmt.Record(el)
+			// the values are read here, so hooks must be called first
+			err := b.runElemHooks(ti, el, tmeta.BeforeInsert)
+			if err != nil {
+				return nil, err
+			}
+			err = ti.Validate(el)
+			if err != nil {
+				return nil, err
+			}
+			stmt = recordValues(stmt, ti, el, recordID)
 		}
 
 	} else { // one record
@@ -198,7 +297,16 @@ func (b *Builder) Insert(o interface{}) (*dbr.InsertStmt, error) {
 		if ctt, ok := o.(UpdateTimeToucher); ok {
 			ctt.UpdateTimeTouch()
 		}
-		stmt = stmt.Record(o)
+		po := hookPtr(o)
+		err := b.runElemHooks(ti, po, tmeta.BeforeInsert)
+		if err != nil {
+			return nil, err
+		}
+		err = ti.Validate(po)
+		if err != nil {
+			return nil, err
+		}
+		stmt = recordValues(stmt, ti, po, recordID)
 	}
 
 	return stmt, nil
@@ -267,6 +375,8 @@ func (b *Builder) MustUpdateByID(o interface{}) *dbr.UpdateStmt {
 // taking into account the update time (if UpdateTimeToucher is supported), version field
 // (if SQLVersionField is not empty).  If using a version field, its value should be the same
 // as it was selected with and this method will attempt to increment it by one.
+// The BeforeUpdate hooks are called after the update time is touched (see BeforeUpdater
+// and tmeta.Meta.AddHook), then the record is checked with tmeta.TableInfo.Validate.
 func (b *Builder) UpdateByID(o interface{}) (*dbr.UpdateStmt, error) {
 
 	// TODO: optimistic locking with version column
@@ -277,37 +387,53 @@ func (b *Builder) UpdateByID(o interface{}) (*dbr.UpdateStmt, error) {
 		return nil, ErrTypeNotRegistered
 	}
 
+	// a struct value is copied so hooks have a pointer, changes they make are still used below
+	po := hookPtr(o)
+
 	// touch the update time if possible
-	po := o
-	if reflect.TypeOf(po).Kind() != reflect.Ptr { // make sure it's a pointer
-		po = reflect.ValueOf(po).Addr().Interface()
-	}
 	if ctt, ok := po.(UpdateTimeToucher); ok {
 		ctt.UpdateTimeTouch()
 	}
 
-	vmap := ti.SQLValueMap(o, false)
+	err := b.runHooks(po, tmeta.BeforeUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ti.Validate(po)
+	if err != nil {
+		return nil, err
+	}
+
+	vmap := ti.SQLValueMap(po, false)
 
 	// extract and increment version value
 	var curVer interface{}
 	if ti.SQLVersionField() != "" {
 		curVer = vmap[ti.SQLVersionField()]
-		if vi, ok := o.(VersionIncrementer); ok {
+		if vi, ok := po.(VersionIncrementer); ok {
 			vi.VersionIncrement()
-			vmap2 := ti.SQLValueMap(o, false) // FIXME: would be a bit more performant if we didn't re-do the whole map here, but it's not doing conversion so not so bad...
+			vmap2 := ti.SQLValueMap(po, false) // FIXME: would be a bit more performant if we didn't re-do the whole map here, but it's not doing conversion so not so bad...
 			vmap[ti.SQLVersionField()] = vmap2[ti.SQLVersionField()]
 		} else {
 			return nil, fmt.Errorf("SQLVersionField is set to %q but VersionIncrement not implemented for type %T", ti.SQLVersionField(), o)
 		}
 	}
 
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: dbr quotes the table and SetMap field names itself, see stmtDialect
 	ustmt := b.Session.
 		Update(ti.SQLName()).
 		SetMap(vmap).
-		Where(ti.SQLPKWhere(), ti.PKValues(o)...)
+		Where(ti.SQLPKWhereQuoted(q), ti.PKValues(po)...)
+	ustmt.Dialect = b.stmtDialect(ustmt.Dialect)
 
 	if ti.SQLVersionField() != "" { // optimistic lock prevents updating record with newer version
-		ustmt = ustmt.Where(ti.SQLVersionField()+" = ?", curVer)
+		ustmt = ustmt.Where(q(ti.SQLVersionField())+" = ?", curVer)
 	}
 
 	return ustmt, nil
@@ -334,6 +460,12 @@ func (b *Builder) MustDeleteByID(o interface{}, ids ...interface{}) *dbr.DeleteS
 // Otherwise the primary keys are extracted from the object provided
 // and, if optimistic locking is enabled for this type, the version number is included
 // in the SQL where clause also.
+//
+// Types with a soft delete field (see TableInfo.SQLSoftDeleteField) return an error, use
+// SoftDeleteByID to mark them deleted instead.
+//
+// The BeforeDelete hooks are called with o (see BeforeDeleter and tmeta.Meta.AddHook),
+// note that if ids are provided o may not have its primary key set.
 func (b *Builder) DeleteByID(o interface{}, ids ...interface{}) (*dbr.DeleteStmt, error) {
 
 	ti := b.Meta.For(o)
@@ -341,19 +473,34 @@ func (b *Builder) DeleteByID(o interface{}, ids ...interface{}) (*dbr.DeleteStmt
 		return nil, ErrTypeNotRegistered
 	}
 
+	if ti.SQLSoftDeleteField() != "" {
+		return nil, fmt.Errorf("DeleteByID would remove records of %v which has soft delete field %q, use SoftDeleteByID instead", ti.GoType(), ti.SQLSoftDeleteField())
+	}
+
+	err := b.runHooks(o, tmeta.BeforeDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
 	dstmt := b.Session.DeleteFrom(ti.SQLName())
+	dstmt.Dialect = b.stmtDialect(dstmt.Dialect)
 	// fill ids if not provided
 	if len(ids) == 0 {
 		ids = ti.PKValues(o)
 		// check for version field and add to where clause
 		if ti.SQLVersionField() != "" {
-			dstmt = dstmt.Where(ti.SQLVersionField()+" = ?",
-				sqlFieldValue(reflect.ValueOf(o), ti.SQLVersionField()))
+			dstmt = dstmt.Where(q(ti.SQLVersionField())+" = ?",
+				sqlFieldValue(derefValue(reflect.ValueOf(o)), ti.SQLVersionField()))
 		}
 	}
 
 	// main where clause by ID(s)
-	dstmt = dstmt.Where(ti.SQLPKWhere(), ids...)
+	dstmt = dstmt.Where(ti.SQLPKWhereQuoted(q), ids...)
 
 	return dstmt, nil
 }
@@ -388,6 +535,8 @@ func (b *Builder) MustSelectRelationPtr(o interface{}, relationName string) (stm
 // field, based on the name of that relation.  The second return value is a pointer to the field
 // which can be passed to stmt.Load() to populate the correct field.
 // The object provided must not be a slice.
+// Soft deleted target records are excluded unless IncludeDeleted is set (BelongsToManyIDs
+// only reads the join table and so is not affected).
 func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *dbr.SelectStmt, fieldPtr interface{}, reterr error) {
 
 	ti := b.Meta.For(o)
@@ -400,6 +549,11 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 		return nil, nil, fmt.Errorf("relation %q not found", relationName)
 	}
 
+	q, err := b.quoter()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	vo := derefValue(reflect.ValueOf(o))
 
 	switch r := rel.(type) {
@@ -413,10 +567,11 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 		}
 
 		stmt = b.Session.
-			Select(targetTI.SQLFields(true)...).
-			From(targetTI.SQLName()).
-			Where(targetTI.SQLPKFields()[0]+" = ?",
+			Select(quoteList(q, targetTI.SQLFields(true))...).
+			From(q(targetTI.SQLName())).
+			Where(q(targetTI.SQLPKFields()[0])+" = ?",
 				sqlFieldValue(vo, r.SQLIDField))
+		stmt = b.selectNotDeleted(stmt, targetTI, q, false)
 		fieldPtr = ti.RelationTargetPtr(o, relationName)
 		return
 
@@ -429,9 +584,10 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 		}
 
 		stmt = b.Session.
-			Select(targetTI.SQLFields(true)...).
-			From(targetTI.SQLName()).
-			Where(r.SQLOtherIDField+" = ?", ti.PKValues(o)[0])
+			Select(quoteList(q, targetTI.SQLFields(true))...).
+			From(q(targetTI.SQLName())).
+			Where(q(r.SQLOtherIDField)+" = ?", ti.PKValues(o)[0])
+		stmt = b.selectNotDeleted(stmt, targetTI, q, false)
 		fieldPtr = ti.RelationTargetPtr(o, relationName)
 		return
 
@@ -444,9 +600,10 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 		}
 
 		stmt = b.Session.
-			Select(targetTI.SQLFields(true)...).
-			From(targetTI.SQLName()).
-			Where(r.SQLOtherIDField+" = ?", ti.PKValues(o)[0])
+			Select(quoteList(q, targetTI.SQLFields(true))...).
+			From(q(targetTI.SQLName())).
+			Where(q(r.SQLOtherIDField)+" = ?", ti.PKValues(o)[0])
+		stmt = b.selectNotDeleted(stmt, targetTI, q, false)
 		fieldPtr = ti.RelationTargetPtr(o, relationName)
 		return
 
@@ -457,17 +614,20 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 		targetType := elemDerefType(vo.FieldByName(r.GoValueField).Type())
 		targetTI := b.Meta.ForType(targetType)
 
+		// NOTE: dbr quotes the join table name itself
 		stmt = b.Session.
 			Select(
-				stringsAddPrefix(targetTI.SQLFields(true), targetTI.SQLName()+".")...,
+				quoteList(q, stringsAddPrefix(targetTI.SQLFields(true), targetTI.SQLName()+"."))...,
 			).
-			From(joinTI.SQLName()).
+			From(q(joinTI.SQLName())).
 			Join(targetTI.SQLName(),
-				fmt.Sprintf(`%s.%s = %s.%s`,
-					joinTI.SQLName(), r.SQLOtherIDField,
-					targetTI.SQLName(), targetTI.SQLPKFields()[0],
+				fmt.Sprintf(`%s = %s`,
+					q(joinTI.SQLName()+"."+r.SQLOtherIDField),
+					q(targetTI.SQLName()+"."+targetTI.SQLPKFields()[0]),
 				)).
-			Where(joinTI.SQLName()+"."+r.SQLIDField+" = ?", ti.PKValues(o)[0])
+			Where(q(joinTI.SQLName()+"."+r.SQLIDField)+" = ?", ti.PKValues(o)[0])
+		stmt.Dialect = b.stmtDialect(stmt.Dialect)
+		stmt = b.selectNotDeleted(stmt, targetTI, q, true)
 		fieldPtr = ti.RelationTargetPtr(o, relationName)
 		return
 
@@ -475,9 +635,9 @@ func (b *Builder) SelectRelationPtr(o interface{}, relationName string) (stmt *d
 
 		joinTI := b.Meta.ForName(r.JoinName)
 		stmt = b.Session.
-			Select(r.SQLOtherIDField).
-			From(joinTI.SQLName()).
-			Where(r.SQLIDField+" = ?", ti.PKValues(o)[0])
+			Select(q(r.SQLOtherIDField)).
+			From(q(joinTI.SQLName())).
+			Where(q(r.SQLIDField)+" = ?", ti.PKValues(o)[0])
 		fieldPtr = ti.RelationTargetPtr(o, relationName)
 		return
 
@@ -525,13 +685,19 @@ func (b *Builder) DeleteRelationNotIn(o interface{}, relationName string) (*dbr.
 		sliceV := derefValue(vo.FieldByName(relv.GoValueField))
 
 		joinTI := b.Meta.ForName(relv.JoinName)
+		q, err := b.quoter()
+		if err != nil {
+			return nil, err
+		}
+
 		stmt := b.Session.DeleteFrom(joinTI.SQLName())
-		stmt = stmt.Where(relv.SQLIDField+" = ?", ti.PKValues(o)[0])
+		stmt.Dialect = b.stmtDialect(stmt.Dialect)
+		stmt = stmt.Where(q(relv.SQLIDField)+" = ?", ti.PKValues(o)[0])
 
 		// if there's something in the slice, we add the NOT IN part,
 		// otherwise we delete all of them (with the above existing where stipulation)
 		if sliceV.Len() > 0 {
-			stmt = stmt.Where(relv.SQLOtherIDField+" NOT IN ?", sliceV.Interface())
+			stmt = stmt.Where(q(relv.SQLOtherIDField)+" NOT IN ?", sliceV.Interface())
 		}
 
 		return stmt, nil
@@ -595,36 +761,21 @@ func (b *Builder) InsertRelationIgnore(o interface{}, relationName string) (*dbr
 		}
 		var valueStr = strings.TrimSuffix(buf.String(), ",")
 
-		// don't we just love random syntax differences between sql dialects...
-		switch b.dbrDialect() {
-
-		case dialect.SQLite3:
-
-			return b.Session.InsertBySql(
-					`INSERT OR IGNORE INTO `+joinTI.SQLName()+
-						`(`+relv.SQLIDField+`,`+relv.SQLOtherIDField+`)`+
-						` VALUES `+valueStr, args...),
-				nil
-
-		case dialect.MySQL:
-
-			return b.Session.InsertBySql(
-					`INSERT IGNORE INTO `+joinTI.SQLName()+
-						`(`+relv.SQLIDField+`,`+relv.SQLOtherIDField+`)`+
-						` VALUES `+valueStr, args...),
-				nil
-
-		case dialect.PostgreSQL:
-
-			return b.Session.InsertBySql(
-					`INSERT INTO `+joinTI.SQLName()+
-						`(`+relv.SQLIDField+`,`+relv.SQLOtherIDField+`)`+
-						` VALUES `+valueStr+` ON CONFLICT DO NOTHING`, args...),
-				nil
-
+		d, err := b.dialect()
+		if err != nil {
+			return nil, err
+		}
+		q, err := b.quoter()
+		if err != nil {
+			return nil, err
 		}
 
-		return nil, fmt.Errorf("unknown dialect %#v", b.dbrDialect())
+		return b.Session.InsertBySql(
+				d.InsertIgnoreSQL(q(joinTI.SQLName()),
+					quoteList(q, []string{relv.SQLIDField, relv.SQLOtherIDField}),
+					valueStr),
+				args...),
+			nil
 
 	}
 
@@ -712,10 +863,12 @@ func (b *Builder) ResultWithOneUpdate(res sql.Result, err error) error {
 // ResultWithInsertID is a helper to handle the result of an insert.
 // If err is non-nil it will be returned.  If the object provided
 // has an auto increment primary key, then LastInsertId is used used
-// to populate it.
+// to populate it.  The AfterInsert hooks are then called (see AfterInsertHooks).
 // TODO: This should be refactored so it can be used on one line, if possible.
 // It doesn't provide the same convenience that ResultWithOneUpdate does due to
 // needing the extra argument.
+// Only one record is populated and Postgres is not supported (lib/pq does not
+// implement LastInsertId), use InsertAndLoadIDs for those cases.
 func (b *Builder) ResultWithInsertID(o interface{}, res sql.Result, err error) error {
 	if err != nil {
 		return err
@@ -745,8 +898,88 @@ func (b *Builder) ResultWithInsertID(o interface{}, res sql.Result, err error) e
 
 	}
 
-	return nil
+	return b.AfterInsertHooks(o, nil)
+
+}
+
+// InsertAndLoadIDs inserts the object(s) provided, as with Insert, and executes the statement.
+// If the primary key is auto increment, it is populated on each record.  Slice is supported.
+// If the Dialect supports it (e.g. Postgres) this is done with a RETURNING clause, otherwise
+// LastInsertId is used and the IDs of a multi-row insert are assumed to be sequential, which
+// is the case for SQLite3 and for MySQL unless innodb_autoinc_lock_mode is set to 2 ("interleaved").
+// The BeforeInsert and AfterInsert hooks are called with ctx.
+func (b *Builder) InsertAndLoadIDs(ctx context.Context, o interface{}) error {
+
+	b = b.WithContext(ctx)
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return ErrTypeNotRegistered
+	}
+
+	stmt, err := b.Insert(o)
+	if err != nil {
+		return err
+	}
+
+	if !ti.PKAutoIncr() {
+		return b.AfterInsertHooks(o, b.ResultOK(stmt.ExecContext(ctx)))
+	}
+
+	if len(ti.SQLPKFields()) != 1 {
+		return fmt.Errorf("cannot load insert IDs because number of PK fields for %v is %d, needs to be exactly 1",
+			ti.GoType(), len(ti.SQLPKFields()))
+	}
+
+	elems, err := structElems(o)
+	if err != nil {
+		return err
+	}
+	n := int64(len(elems))
+
+	d, err := b.dialect()
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	if d.SupportsReturning() {
+		err := stmt.Returning(ti.SQLPKFields()[0]).LoadContext(ctx, &ids)
+		if err != nil {
+			return err
+		}
+		if int64(len(ids)) != n {
+			return fmt.Errorf("expected %d IDs to be returned from insert, got %d", n, len(ids))
+		}
+	} else {
+		res, err := stmt.ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != n {
+			return fmt.Errorf("expected %d rows to be inserted, got %d", n, rows)
+		}
+		ids, err = d.InsertIDs(res, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, elv := range elems {
+		pkf := elv.FieldByIndex(ti.FieldBySQLName(ti.SQLPKFields()[0]).Index)
+		switch pkf.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			pkf.SetUint(uint64(ids[i]))
+		default:
+			pkf.SetInt(ids[i])
+		}
+	}
 
+	return b.AfterInsertHooks(o, nil)
 }
 
 // sess.DeleteJoinStringNotIn("book_category", "book_id", bookID, "category_id", categoryIDs...)
@@ -760,4 +993,5 @@ func (b *Builder) ResultWithInsertID(o interface{}, res sql.Result, err error) e
 
 // auto increment ids DONE
 // optimistic locking DONE
-// update with difference... - and can we optimistic lock and merge non-conflicting changes???
+// update with difference DONE
+// optimistic lock and merge non-conflicting changes DONE
@@ -1,13 +1,20 @@
 package tmetadbr
 
 import (
+	"bytes"
+	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/gocaveman/tmeta"
+	"github.com/gocaveman/tmeta/tmetautil"
 	"github.com/gocraft/dbr"
+	"github.com/gocraft/dbr/dialect"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -85,16 +92,666 @@ func TestTx(t *testing.T) {
 
 }
 
+// Order has table and field names which must be quoted
+type Order struct {
+	OrderID string `db:"order_id" tmeta:"pk"`
+	User    string `db:"user"`
+	Group   string `db:"group"`
+	Version int64  `db:"version" tmeta:"version"`
+}
+
+func (o *Order) VersionIncrement() { o.Version++ }
+
+func TestQuoting(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sess.Exec(`
+CREATE TABLE "test-order" (
+	"order_id" VARCHAR(64),
+	"user" VARCHAR(255),
+	"group" VARCHAR(255),
+	"version" INTEGER NOT NULL,
+	PRIMARY KEY("order_id")
+)`)
+	assert.NoError(err)
+	meta.MustParse(Order{})
+	meta.For(Order{}).SetSQLName("test-order")
+
+	b := New(sess, meta)
+
+	order := Order{OrderID: "order_0001", User: "joe", Group: "admin"}
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&order).Exec()))
+
+	var order2 Order
+	assert.NoError(b.MustSelectByID(&order2, "order_0001").LoadOne(&order2))
+	assert.Equal("admin", order2.Group)
+
+	order2.User = "joseph"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateByID(&order2).Exec()))
+
+	order2.Group = "user"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpsert(&order2).Exec()))
+
+	var orderList []Order
+	_, err = b.MustSelect(&orderList).Load(&orderList)
+	assert.NoError(err)
+	assert.Len(orderList, 1)
+	assert.Equal("joseph", orderList[0].User)
+	assert.Equal("user", orderList[0].Group)
+	assert.Equal(int64(2), orderList[0].Version)
+
+	// without quoting the names are used as-is
+	b.DisableQuoting = true
+	_, err = b.MustSelect(&orderList).Load(&orderList)
+	assert.Error(err)
+	b.DisableQuoting = false
+
+	assert.NoError(b.ResultWithOneUpdate(b.MustDeleteByID(&orderList[0]).Exec()))
+
+	// an SQLName which is already quoted is used as-is by statements dbr builds too
+	type Gadget struct {
+		GadgetID string `db:"gadget_id" tmeta:"pk"`
+		Name     string `db:"name"`
+	}
+	_, err = sess.Exec(`CREATE TABLE "test-gadget" (gadget_id VARCHAR(64), name VARCHAR(255), PRIMARY KEY(gadget_id))`)
+	assert.NoError(err)
+	meta.MustParse(Gadget{})
+	meta.For(Gadget{}).SetSQLName(`"test-gadget"`)
+	b.DisableQuoting = true
+	gadget := Gadget{GadgetID: "gadget_0001", Name: "sprocket"}
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&gadget).Exec()))
+	gadget.Name = "cog"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateByID(&gadget).Exec()))
+	var gadget2 Gadget
+	assert.NoError(b.MustSelectByID(&gadget2, "gadget_0001").LoadOne(&gadget2))
+	assert.Equal("cog", gadget2.Name)
+	assert.NoError(b.ResultWithOneUpdate(b.MustDeleteByID(&gadget2).Exec()))
+
+}
+
+func TestSelectCriteria(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+
+	assert.NoError(b.ExecOK(b.MustInsert([]Book{
+		{BookID: "book_0001", AuthorID: "author_0001", Title: "Tom Sawyer"},
+		{BookID: "book_0002", AuthorID: "author_0001", Title: "Huckleberry Finn"},
+		{BookID: "book_0003", AuthorID: "author_0002", Title: "Alice in Wonderland"},
+		{BookID: "book_0004", AuthorID: "author_0003", Title: "Middlemarch"},
+	})))
+
+	var bookList []Book
+	_, err = b.MustSelectCriteria(&bookList, tmetautil.Criteria{
+		{Or: tmetautil.Criteria{
+			{Field: "author_id", Op: tmetautil.EqOp, Value: "author_0001"},
+			{Field: "title", Op: tmetautil.LikeOp, Value: "Alice%"},
+		}},
+	}, tmetautil.OrderByList{{Field: "author_id", Desc: true}, {Field: "title"}}, 2, 1).Load(&bookList)
+	assert.NoError(err)
+	if assert.Len(bookList, 2) {
+		assert.Equal("Huckleberry Finn", bookList[0].Title)
+		assert.Equal("Tom Sawyer", bookList[1].Title)
+	}
+
+	// offset without limit
+	bookList = nil
+	_, err = b.MustSelectCriteria(&bookList, nil, tmetautil.OrderByList{{Field: "book_id"}}, -1, 3).Load(&bookList)
+	assert.NoError(err)
+	if assert.Len(bookList, 1) {
+		assert.Equal("book_0004", bookList[0].BookID)
+	}
+	pb := New(sess, meta)
+	pb.Dialect = tmeta.PostgresDialect{}
+	buf := dbr.NewBuffer()
+	assert.NoError(pb.MustSelectCriteria(&bookList, nil, nil, -1, 3).Build(dialect.PostgreSQL, buf))
+	assert.True(strings.HasSuffix(buf.String(), ` OFFSET 3`), buf.String())
+	assert.NotContains(buf.String(), `LIMIT`)
+
+	// other operators
+	for _, tc := range []struct {
+		c   tmetautil.Criterion
+		ids []string
+	}{
+		{tmetautil.Criterion{Field: "title", Op: tmetautil.StartsWithOp, Value: "Tom"}, []string{"book_0001"}},
+		{tmetautil.Criterion{Field: "title", Op: tmetautil.StartsWithOp, Value: "Tom%"}, nil},
+		{tmetautil.Criterion{Field: "title", Op: tmetautil.ContainsOp, Value: "in"}, []string{"book_0002", "book_0003"}},
+		{tmetautil.Criterion{Field: "title", Op: tmetautil.ILikeOp, Value: "%WONDER%"}, []string{"book_0003"}},
+		{tmetautil.Criterion{Field: "book_id", Op: tmetautil.BetweenOp, Value: []string{"book_0002", "book_0003"}}, []string{"book_0002", "book_0003"}},
+		{tmetautil.Criterion{Field: "author_id", Op: tmetautil.NotInOp, Value: []string{"author_0001", "author_0002"}}, []string{"book_0004"}},
+		{tmetautil.Criterion{Field: "publisher_id", Op: tmetautil.IsNullOp}, nil}, // empty string is not NULL
+		{tmetautil.Criterion{Field: "publisher_id", Op: tmetautil.NotNullOp}, []string{"book_0001", "book_0002", "book_0003", "book_0004"}},
+	} {
+		bookList = nil
+		_, err = b.MustSelectCriteria(&bookList, tmetautil.Criteria{tc.c}, tmetautil.OrderByList{{Field: "book_id"}}, -1, 0).Load(&bookList)
+		assert.NoError(err)
+		var ids []string
+		for _, book := range bookList {
+			ids = append(ids, book.BookID)
+		}
+		assert.Equal(tc.ids, ids, "%+v", tc.c)
+	}
+
+	// field names are checked
+	_, err = b.SelectCriteria(&bookList, tmetautil.Criteria{{Field: "1=1; --", Op: tmetautil.EqOp, Value: 1}}, nil, -1, 0)
+	assert.Error(err)
+	_, err = b.SelectCriteria(&bookList, nil, tmetautil.OrderByList{{Field: "(SELECT 1)"}}, -1, 0)
+	assert.Error(err)
+
+}
+
+// noRowValuesDialect makes KeysetWhere use the expanded form
+type noRowValuesDialect struct{ tmeta.SQLite3Dialect }
+
+func (noRowValuesDialect) SupportsRowValues() bool { return false }
+
+func TestKeyset(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	ti := b.For(Book{})
+	codec := NewCursorCodec([]byte("0123456789abcdef0123456789abcdef"))
+
+	assert.NoError(b.ExecOK(b.MustInsert([]Book{
+		{BookID: "book_0001", AuthorID: "author_0001", Title: "Tom Sawyer"},
+		{BookID: "book_0002", AuthorID: "author_0001", Title: "Huckleberry Finn"},
+		{BookID: "book_0003", AuthorID: "author_0002", Title: "Alice in Wonderland"},
+		{BookID: "book_0004", AuthorID: "author_0003", Title: "Middlemarch"},
+		{BookID: "book_0005", AuthorID: "author_0003", Title: "Middlemarch"},
+	})))
+
+	// page through with a page size of 2, returning the IDs in order
+	readAll := func(b *Builder, orderBy tmetautil.OrderByList) (ret []string) {
+		cursor := ""
+		for i := 0; i < 10; i++ {
+			after, err := codec.Decode(ti, orderBy, cursor)
+			if !assert.NoError(err) {
+				return
+			}
+			var bookList []Book
+			_, err = b.MustSelectKeyset(&bookList, nil, orderBy, after, 2).Load(&bookList)
+			if !assert.NoError(err) || len(bookList) == 0 {
+				return
+			}
+			for _, book := range bookList {
+				ret = append(ret, book.BookID)
+			}
+			cursor, err = codec.Encode(ti, orderBy, &bookList[len(bookList)-1])
+			assert.NoError(err)
+		}
+		return
+	}
+
+	noRowValues := New(sess, meta)
+	noRowValues.Dialect = noRowValuesDialect{}
+
+	for _, bb := range []*Builder{b, noRowValues} {
+		// same direction, tie broken by primary key
+		assert.Equal([]string{"book_0003", "book_0002", "book_0004", "book_0005", "book_0001"},
+			readAll(bb, tmetautil.OrderByList{{Field: "title"}}))
+		assert.Equal([]string{"book_0001", "book_0005", "book_0004", "book_0002", "book_0003"},
+			readAll(bb, tmetautil.OrderByList{{Field: "title", Desc: true}, {Field: "book_id", Desc: true}}))
+		// mixed directions
+		assert.Equal([]string{"book_0004", "book_0005", "book_0003", "book_0002", "book_0001"},
+			readAll(bb, tmetautil.OrderByList{{Field: "author_id", Desc: true}, {Field: "title"}}))
+	}
+
+	orderBy := tmetautil.OrderByList{{Field: "author_id", Desc: true}, {Field: "title"}}
+	where, args, err := noRowValues.KeysetWhere(&Book{}, KeysetOrderBy(ti, orderBy), []interface{}{"a", "t", "b"})
+	assert.NoError(err)
+	assert.Equal(`("author_id" < ? OR ("author_id" = ? AND "title" > ?) OR ("author_id" = ? AND "title" = ? AND "book_id" > ?))`, where)
+	assert.Equal([]interface{}{"a", "a", "t", "a", "t", "b"}, args)
+	where, _, err = b.KeysetWhere(&Book{}, KeysetOrderBy(ti, tmetautil.OrderByList{{Field: "title"}}), []interface{}{"t", "b"})
+	assert.NoError(err)
+	assert.Equal(`("title", "book_id") > (?, ?)`, where)
+
+	// cursors can't be altered or reused with another sort
+	cursor, err := codec.Encode(ti, orderBy, &Book{BookID: "book_0003", AuthorID: "author_0002", Title: "Alice in Wonderland"})
+	assert.NoError(err)
+	after, err := codec.Decode(ti, orderBy, cursor)
+	assert.NoError(err)
+	assert.Equal([]interface{}{"author_0002", "Alice in Wonderland", "book_0003"}, after)
+	_, err = codec.Decode(ti, tmetautil.OrderByList{{Field: "author_id"}, {Field: "title"}}, cursor)
+	assert.Equal(ErrInvalidCursor, err)
+	_, err = codec.Decode(ti, orderBy, "x"+cursor)
+	assert.Equal(ErrInvalidCursor, err)
+	_, err = NewCursorCodec([]byte("another key")).Decode(ti, orderBy, cursor)
+	assert.Equal(ErrInvalidCursor, err)
+
+}
+
+func TestCountExists(t *testing.T) {
+
+	assert := assert.New(t)
+	ctx := context.Background()
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+
+	assert.NoError(b.ExecOK(b.MustInsert([]Book{
+		{BookID: "book_0001", AuthorID: "author_0001", Title: "Tom Sawyer"},
+		{BookID: "book_0002", AuthorID: "author_0001", Title: "Huckleberry Finn"},
+		{BookID: "book_0003", AuthorID: "author_0002", Title: "Alice in Wonderland"},
+		{BookID: "book_0004", AuthorID: "author_0003", Title: "Middlemarch"},
+		{BookID: "book_0005", AuthorID: "author_0003", Title: "Daniel Deronda"},
+	})))
+
+	var n int64
+	assert.NoError(b.MustCount(Book{}, nil).LoadOne(&n))
+	assert.Equal(int64(5), n)
+	assert.NoError(b.MustCount(&[]Book{}, tmetautil.Criteria{{Field: "author_id", Op: tmetautil.EqOp, Value: "author_0001"}}).LoadOne(&n))
+	assert.Equal(int64(2), n)
+	_, err = b.Count(Book{}, tmetautil.Criteria{{Field: "bogus", Op: tmetautil.EqOp, Value: 1}})
+	assert.Error(err)
+
+	var one int
+	assert.NoError(b.MustExists(Book{}, "book_0003").LoadOne(&one))
+	assert.NoError(b.MustExists(&Book{BookID: "book_0004"}).LoadOne(&one))
+	assert.Equal(dbr.ErrNotFound, b.MustExists(Book{}, "book_9999").LoadOne(&one))
+
+	for _, tc := range []struct {
+		limit, offset int64
+		n             int
+		total         int64
+	}{
+		{2, 0, 2, 5},  // full page, counted
+		{2, 4, 1, 5},  // last page, not counted
+		{10, 0, 5, 5}, // everything on the first page
+		{-1, 1, 4, 5}, // no limit
+		{2, 10, 0, 5}, // past the end, counted
+	} {
+		var bookList []Book
+		total, err := b.LoadPage(ctx, &bookList, nil, tmetautil.OrderByList{{Field: "book_id"}}, tc.limit, tc.offset)
+		assert.NoError(err)
+		assert.Len(bookList, tc.n, "%+v", tc)
+		assert.Equal(tc.total, total, "%+v", tc)
+	}
+
+	var bookList []Book
+	total, err := b.LoadPage(ctx, &bookList, tmetautil.Criteria{{Field: "title", Op: tmetautil.LikeOp, Value: "%n%"}}, nil, 2, 0)
+	assert.NoError(err)
+	assert.Len(bookList, 2)
+	assert.Equal(int64(3), total)
+
+	_, err = b.LoadPage(ctx, &Book{}, nil, nil, 2, 0)
+	assert.Error(err)
+
+}
+
 func TestCRUDVersion(t *testing.T) {
 	t.Logf("TODO: TestCRUDVersion")
 	t.SkipNow()
 }
 
+func TestUpdateChanged(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Book{
+		BookID:   "book_0001",
+		AuthorID: "author_0001",
+		Title:    "Tom Sawyer",
+	}).Exec()))
+
+	// two concurrent edits of different fields both stick
+	var book1, book2 Book
+	assert.NoError(b.MustSelectByID(&book1, "book_0001").LoadOne(&book1))
+	assert.NoError(b.MustSelectByID(&book2, "book_0001").LoadOne(&book2))
+	orig1, orig2 := book1, book2
+	book1.Title = "The Adventures of Tom Sawyer"
+	book2.AuthorID = "author_0002"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateChanged(orig1, &book1).Exec()))
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateChanged(orig2, &book2).Exec()))
+	var book3 Book
+	assert.NoError(b.MustSelectByID(&book3, "book_0001").LoadOne(&book3))
+	assert.Equal("The Adventures of Tom Sawyer", book3.Title)
+	assert.Equal("author_0002", book3.AuthorID)
+
+	// nothing changed, nothing to do
+	stmt, err := b.UpdateChanged(book3, &book3)
+	assert.NoError(err)
+	assert.Nil(stmt)
+	stmt, err = b.UpdateChanged(book3, book3) // a struct value works too
+	assert.NoError(err)
+	assert.Nil(stmt)
+
+	// optimistic locking still applies
+	publisher := Publisher{PublisherID: "publisher_0001", CompanyName: "Tor"}
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&publisher).Exec()))
+	stale := publisher
+	orig := publisher
+	publisher.CompanyName = "Tor Books"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateChanged(orig, &publisher).Exec()))
+	assert.Equal(int64(1), publisher.Version)
+	stale.CompanyName = "Stale"
+	assert.Equal(ErrUpdateFailed, b.ResultWithOneUpdate(b.MustUpdateChanged(orig, &stale).Exec()))
+
+	// must be the same type
+	_, err = b.UpdateChanged(Author{}, &book3)
+	assert.Error(err)
+
+}
+
+// Article has several fields and a version
+type Article struct {
+	ArticleID string `db:"article_id" tmeta:"pk"`
+	Title     string `db:"title"`
+	Body      string `db:"body"`
+	Version   int64  `db:"version" tmeta:"version"`
+}
+
+func (a *Article) VersionIncrement() { a.Version++ }
+
+func TestUpdateMerge(t *testing.T) {
+
+	assert := assert.New(t)
+	ctx := context.Background()
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sess.Exec(`
+CREATE TABLE article (
+	article_id VARCHAR(64),
+	title VARCHAR(255),
+	body TEXT,
+	version INTEGER NOT NULL,
+	PRIMARY KEY(article_id)
+)`)
+	assert.NoError(err)
+	meta.MustParse(Article{})
+
+	b := New(sess, meta)
+
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Article{ArticleID: "article_0001", Title: "Draft", Body: "Lorem"}).Exec()))
+
+	var mine, theirs Article
+	assert.NoError(b.MustSelectByID(&mine, "article_0001").LoadOne(&mine))
+	assert.NoError(b.MustSelectByID(&theirs, "article_0001").LoadOne(&theirs))
+	base := mine
+
+	// someone else changes the body first, our title change is merged in
+	theirs.Body = "Lorem ipsum"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateByID(&theirs).Exec()))
+	mine.Title = "Final"
+	assert.NoError(b.UpdateMerge(ctx, base, &mine))
+	assert.Equal("Lorem ipsum", mine.Body)
+	assert.Equal(int64(2), mine.Version)
+	var article Article
+	assert.NoError(b.MustSelectByID(&article, "article_0001").LoadOne(&article))
+	assert.Equal(mine, article)
+
+	// both change the same field
+	base = mine
+	theirs = mine
+	theirs.Title = "Their Title"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpdateByID(&theirs).Exec()))
+	mine.Title = "My Title"
+	mine.Body = "Dolor"
+	err = b.UpdateMerge(ctx, base, &mine)
+	if assert.IsType(&ConflictError{}, err) {
+		cerr := err.(*ConflictError)
+		assert.Equal([]string{"title"}, cerr.SQLFields)
+		assert.Equal("Their Title", cerr.Current.(*Article).Title)
+	}
+	assert.Equal(int64(2), mine.Version) // unchanged on failure
+
+	// same change by both is not a conflict
+	base = theirs
+	base.Version--
+	base.Title = "Final"
+	mine = theirs
+	mine.Version--
+	assert.NoError(b.UpdateMerge(ctx, base, &mine))
+	assert.Equal(int64(3), mine.Version)
+
+}
+
+// testPQError looks like a *pq.Error
+type testPQError map[byte]string
+
+func (e testPQError) Error() string     { return "pq: " + e['M'] }
+func (e testPQError) Get(k byte) string { return e[k] }
+
+func TestErrors(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+
+	// not found vs version conflict
+	author := Author{AuthorID: "author_0001", NomDePlume: "Mark Twain"}
+	err = b.TranslateError(&author, b.ResultWithOneUpdate(b.MustUpdateByID(&author).Exec()))
+	assert.Equal(ErrNotFound, err)
+	assert.True(errors.Is(err, ErrUpdateFailed))
+
+	publisher := Publisher{PublisherID: "publisher_0001", CompanyName: "Tor"}
+	err = b.TranslateError(&publisher, b.ResultWithOneUpdate(b.MustUpdateByID(&publisher).Exec()))
+	assert.Equal(ErrNotFound, err)
+	publisher = Publisher{PublisherID: "publisher_0001", CompanyName: "Tor"}
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&publisher).Exec()))
+	stale := publisher
+	assert.NoError(b.TranslateError(&publisher, b.ResultWithOneUpdate(b.MustUpdateByID(&publisher).Exec())))
+	err = b.TranslateError(&stale, b.ResultWithOneUpdate(b.MustUpdateByID(&stale).Exec()))
+	assert.Equal(ErrVersionConflict, err)
+	assert.True(errors.Is(err, ErrUpdateFailed))
+
+	// duplicate key from the database
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&author).Exec()))
+
+	// without a version field a record which exists is not reported as not found, MySQL
+	// for example does not count it as affected if the update didn't change anything
+	assert.Equal(ErrUpdateFailed, b.TranslateError(&author, ErrUpdateFailed))
+	err = b.TranslateError(&author, b.ResultWithOneUpdate(b.MustInsert(&author).Exec()))
+	if assert.IsType(&ConstraintError{}, err) {
+		cerr := err.(*ConstraintError)
+		assert.Equal(DuplicateKey, cerr.Kind)
+		assert.Equal("test_author", cerr.SQLName)
+		assert.Equal([]string{"author_id"}, cerr.SQLFields)
+	}
+
+	// messages from each driver
+	book := &Book{}
+	for _, tc := range []struct {
+		err    error
+		kind   ConstraintKind
+		fields []string
+	}{
+		{fmt.Errorf("UNIQUE constraint failed: test_book.author_id, test_book.title"), DuplicateKey, []string{"author_id", "title"}},
+		{fmt.Errorf("FOREIGN KEY constraint failed"), ForeignKey, nil},
+		{fmt.Errorf("Error 1062: Duplicate entry 'book_0001' for key 'PRIMARY'"), DuplicateKey, []string{"book_id"}},
+		{fmt.Errorf("Error 1062 (23000): Duplicate entry 'x' for key 'test_book.title'"), DuplicateKey, []string{"title"}},
+		{fmt.Errorf("Error 1452: Cannot add or update a child row: a foreign key constraint fails (`db`.`test_book`, CONSTRAINT `fk_author` FOREIGN KEY (`author_id`) REFERENCES `test_author` (`author_id`))"), ForeignKey, []string{"author_id"}},
+		{testPQError{'C': "23505", 'M': "duplicate key value violates unique constraint", 'D': "Key (book_id)=(book_0001) already exists."}, DuplicateKey, []string{"book_id"}},
+		{testPQError{'C': "23503", 'M': "insert or update violates foreign key constraint", 'D': `Key (publisher_id)=(x) is not present in table "test_publisher".`}, ForeignKey, []string{"publisher_id"}},
+	} {
+		err := b.TranslateError(book, tc.err)
+		if assert.IsType(&ConstraintError{}, err, tc.err.Error()) {
+			cerr := err.(*ConstraintError)
+			assert.Equal(tc.kind, cerr.Kind, tc.err.Error())
+			assert.Equal("test_book", cerr.SQLName)
+			assert.Equal(tc.fields, cerr.SQLFields, tc.err.Error())
+			assert.Equal(tc.err, errors.Unwrap(err))
+		}
+	}
+
+	// other errors are left alone
+	otherErr := fmt.Errorf("Error 1045: Access denied")
+	assert.Equal(otherErr, b.TranslateError(book, otherErr))
+	pqOtherErr := testPQError{'C': "42P01", 'M': `relation "nope" does not exist`}
+	assert.Equal(error(pqOtherErr), b.TranslateError(book, pqOtherErr))
+
+}
+
+func TestRepo(t *testing.T) {
+
+	assert := assert.New(t)
+	ctx := context.Background()
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	authorRepo := NewRepo[Author](b)
+
+	for _, a := range []Author{
+		{AuthorID: "author_0001", NomDePlume: "Mark Twain"},
+		{AuthorID: "author_0002", NomDePlume: "Lewis Carroll"},
+		{AuthorID: "author_0003", NomDePlume: "George Eliot"},
+	} {
+		assert.NoError(authorRepo.Create(ctx, &a))
+	}
+	var cerr *ConstraintError
+	assert.True(errors.As(authorRepo.Create(ctx, &Author{AuthorID: "author_0001"}), &cerr))
+
+	author, err := authorRepo.Get(ctx, "author_0002")
+	assert.NoError(err)
+	assert.Equal("Lewis Carroll", author.NomDePlume)
+	_, err = authorRepo.Get(ctx, "author_9999")
+	assert.Equal(ErrNotFound, err)
+
+	authorList, err := authorRepo.List(ctx, nil, tmetautil.OrderByList{{Field: "nom_de_plume", Desc: true}}, 2, 0)
+	assert.NoError(err)
+	if assert.Len(authorList, 2) {
+		assert.Equal("Mark Twain", authorList[0].NomDePlume)
+		assert.Equal("Lewis Carroll", authorList[1].NomDePlume)
+	}
+	authorList, err = authorRepo.List(ctx, nil, tmetautil.OrderByList{{Field: "nom_de_plume"}}, -1, 1)
+	assert.NoError(err)
+	assert.Len(authorList, 2)
+	authorList, err = authorRepo.List(ctx, tmetautil.Criteria{{Field: "nom_de_plume", Op: tmetautil.LikeOp, Value: "%Eliot"}}, nil, -1, 0)
+	assert.NoError(err)
+	assert.Len(authorList, 1)
+	_, err = authorRepo.List(ctx, tmetautil.Criteria{{Field: "password", Op: tmetautil.EqOp, Value: "x"}}, nil, -1, 0)
+	assert.Error(err)
+	_, err = authorRepo.List(ctx, nil, tmetautil.OrderByList{{Field: "password"}}, -1, 0)
+	assert.Error(err)
+
+	n, err := authorRepo.Count(ctx, nil)
+	assert.NoError(err)
+	assert.Equal(int64(3), n)
+	n, err = authorRepo.Count(ctx, tmetautil.Criteria{{Field: "author_id", Op: tmetautil.InOp, Value: []string{"author_0001", "author_0002"}}})
+	assert.NoError(err)
+	assert.Equal(int64(2), n)
+
+	authorList, n, err = authorRepo.ListPage(ctx, nil, tmetautil.OrderByList{{Field: "author_id"}}, 2, 2)
+	assert.NoError(err)
+	assert.Len(authorList, 1)
+	assert.Equal(int64(3), n)
+
+	ok, err := authorRepo.Exists(ctx, "author_0003")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = authorRepo.Exists(ctx, "author_9999")
+	assert.NoError(err)
+	assert.False(ok)
+
+	author.NomDePlume = "Charles Dodgson"
+	assert.NoError(authorRepo.Update(ctx, author))
+	author, err = authorRepo.Get(ctx, "author_0002")
+	assert.NoError(err)
+	assert.Equal("Charles Dodgson", author.NomDePlume)
+
+	assert.NoError(authorRepo.Delete(ctx, author))
+	assert.Equal(ErrNotFound, authorRepo.Delete(ctx, author))
+	assert.Equal(ErrNotFound, authorRepo.Update(ctx, author))
+
+	// auto increment key is populated
+	categoryInfo := CategoryInfo{CategoryID: "category_0001", InfoStuff: "stuff"}
+	assert.NoError(NewRepo[CategoryInfo](b).Create(ctx, &categoryInfo))
+	assert.NotZero(categoryInfo.CategoryInfoID)
+
+	// unregistered type
+	_, err = NewRepo[Order](b).Get(ctx, "order_0001")
+	assert.Equal(ErrTypeNotRegistered, err)
+
+}
+
 func TestAutoIncrement(t *testing.T) {
-	t.Logf("TODO: TestAutoIncrement")
-	t.SkipNow()
-	// TODO: make sure last insert id acts like it's supposed to
-	// TODO: what about uuid generation? is that even still called?
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	ctx := context.Background()
+
+	// last insert id for one record
+	categoryInfo := CategoryInfo{CategoryID: "category_0001"}
+	res, err := b.MustInsert(&categoryInfo).Exec()
+	assert.NoError(b.ResultWithInsertID(&categoryInfo, res, err))
+	assert.Equal(int64(1), categoryInfo.CategoryInfoID)
+
+	// one record
+	categoryInfo = CategoryInfo{CategoryID: "category_0002"}
+	assert.NoError(b.InsertAndLoadIDs(ctx, &categoryInfo))
+	assert.Equal(int64(2), categoryInfo.CategoryInfoID)
+
+	// multiple records
+	categoryInfoList := []*CategoryInfo{
+		{CategoryID: "category_0003"},
+		{CategoryID: "category_0004"},
+		{CategoryID: "category_0005"},
+	}
+	assert.NoError(b.InsertAndLoadIDs(ctx, categoryInfoList))
+	for i, ci := range categoryInfoList {
+		assert.Equal(int64(i+3), ci.CategoryInfoID)
+		var ci2 CategoryInfo
+		assert.NoError(b.MustSelectByID(&ci2, ci.CategoryInfoID).LoadOne(&ci2))
+		assert.Equal(ci.CategoryID, ci2.CategoryID)
+	}
+
+	// not auto increment, just inserts
+	assert.NoError(b.InsertAndLoadIDs(ctx, []Author{{AuthorID: "author_0001"}, {AuthorID: "author_0002"}}))
+	var authorList []Author
+	_, err = b.MustSelect(&authorList).Load(&authorList)
+	assert.NoError(err)
+	assert.Len(authorList, 2)
+
+	// dbr sets an int64 field called "id" itself, which still works
+	type Ticket struct {
+		ID      int64  `db:"id" tmeta:"pk,auto_incr"`
+		Subject string `db:"subject"`
+	}
+	_, err = sess.Exec(`CREATE TABLE ticket (id INTEGER PRIMARY KEY AUTOINCREMENT, subject VARCHAR(255))`)
+	assert.NoError(err)
+	meta.MustParse(Ticket{})
+	ticket := Ticket{Subject: "Broken"}
+	assert.NoError(b.ExecOK(b.MustInsert(&ticket)))
+	assert.Equal(int64(1), ticket.ID)
+
 }
 
 func TestRelationBelongsTo(t *testing.T) {
@@ -304,6 +961,655 @@ func TestRelationBelongsToManyIDs(t *testing.T) {
 
 }
 
+func TestLoadRelation(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Author{
+			AuthorID:   fmt.Sprintf("author_%04d", i),
+			NomDePlume: fmt.Sprintf("Author %d", i),
+		}).Exec()))
+	}
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Category{
+		CategoryID: "category_0001",
+		Name:       "Science Fiction",
+	}).Exec()))
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Category{
+		CategoryID: "category_0002",
+		Name:       "Adventure",
+	}).Exec()))
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&CategoryInfo{
+		CategoryID: "category_0001",
+		InfoStuff:  "info stuff value",
+	}).Exec()))
+
+	// author_0001 has two books, author_0002 has one, author_0003 has none
+	for i, authorID := range []string{"author_0001", "author_0001", "author_0002"} {
+		book := Book{
+			BookID:         fmt.Sprintf("book_%04d", i+1),
+			Title:          fmt.Sprintf("Book %d", i+1),
+			AuthorID:       authorID,
+			CategoryIDList: []string{"category_0001"},
+		}
+		if i == 0 {
+			book.CategoryIDList = append(book.CategoryIDList, "category_0002")
+		}
+		assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&book).Exec()))
+		assert.NoError(b.ExecOK(b.MustInsertRelationIgnore(&book, "category_id_list")))
+	}
+
+	// has many
+	var authorList []Author
+	_, err = b.MustSelect(&authorList).OrderAsc("author_id").Load(&authorList)
+	assert.NoError(err)
+	assert.Len(authorList, 3)
+	assert.NoError(b.LoadRelation(ctx, authorList, "book_list"))
+	assert.Len(authorList[0].BookList, 2)
+	assert.Len(authorList[1].BookList, 1)
+	assert.Len(authorList[2].BookList, 0)
+
+	// belongs to, with pointers
+	var bookList []*Book
+	_, err = b.MustSelect(&bookList).OrderAsc("book_id").Load(&bookList)
+	assert.NoError(err)
+	assert.Len(bookList, 3)
+	assert.NoError(b.LoadRelation(ctx, &bookList, "author"))
+	assert.Equal("Author 1", bookList[0].Author.NomDePlume)
+	assert.Equal("Author 1", bookList[1].Author.NomDePlume)
+	assert.Equal("Author 2", bookList[2].Author.NomDePlume)
+	assert.Nil(bookList[0].Publisher)
+
+	// belongs to with no IDs set leaves the field empty
+	assert.NoError(b.LoadRelation(ctx, &bookList, "publisher"))
+	assert.Nil(bookList[0].Publisher)
+
+	// belongs to many and belongs to many IDs
+	assert.NoError(b.LoadRelation(ctx, &bookList, "category_list"))
+	assert.Len(bookList[0].CategoryList, 2)
+	assert.Len(bookList[1].CategoryList, 1)
+	assert.Equal("Science Fiction", bookList[2].CategoryList[0].Name)
+	assert.NoError(b.LoadRelation(ctx, &bookList, "category_id_list"))
+	assert.Len(bookList[0].CategoryIDList, 2)
+	assert.Equal([]string{"category_0001"}, bookList[1].CategoryIDList)
+
+	// has one
+	var categoryList []Category
+	_, err = b.MustSelect(&categoryList).OrderAsc("category_id").Load(&categoryList)
+	assert.NoError(err)
+	assert.NoError(b.LoadRelation(ctx, categoryList, "category_info"))
+	assert.Equal("info stuff value", categoryList[0].CategoryInfo.InfoStuff)
+	assert.Nil(categoryList[1].CategoryInfo)
+
+	// single struct pointer
+	var author Author
+	assert.NoError(b.MustSelectByID(&author, "author_0001").LoadOne(&author))
+	assert.NoError(b.LoadRelation(ctx, &author, "book_list"))
+	assert.Len(author.BookList, 2)
+
+	assert.Error(b.LoadRelation(ctx, &author, "not_a_relation"))
+
+}
+
+// Token has a []byte primary key, which can't be used as a map key directly
+type Token struct {
+	TokenID  []byte     `db:"token_id" tmeta:"pk"`
+	Name     string     `db:"name"`
+	UseList  []TokenUse `db:"-" tmeta:"has_many"`
+	UseCount int64      `db:"-" tmeta:"count_of=use_list"`
+}
+
+type TokenUse struct {
+	TokenUseID string `db:"token_use_id" tmeta:"pk"`
+	TokenID    []byte `db:"token_id"`
+}
+
+func TestLoadRelationBytesID(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE token (token_id BLOB, name VARCHAR(255), PRIMARY KEY(token_id))`,
+		`CREATE TABLE token_use (token_use_id VARCHAR(64), token_id BLOB, PRIMARY KEY(token_use_id))`,
+	} {
+		_, err = sess.Exec(stmt)
+		assert.NoError(err)
+	}
+	meta.MustParse(Token{})
+	meta.MustParse(TokenUse{})
+
+	b := New(sess, meta)
+	ctx := context.Background()
+
+	assert.NoError(b.ExecOK(b.MustInsert([]Token{{TokenID: []byte{1, 2}, Name: "one"}, {TokenID: []byte{3}, Name: "two"}})))
+	assert.NoError(b.ExecOK(b.MustInsert([]TokenUse{
+		{TokenUseID: "token_use_0001", TokenID: []byte{1, 2}},
+		{TokenUseID: "token_use_0002", TokenID: []byte{1, 2}},
+	})))
+
+	// the same token twice, the IDs are still de-duplicated
+	tokenList := []Token{{TokenID: []byte{1, 2}}, {TokenID: []byte{3}}, {TokenID: []byte{1, 2}}}
+	elems, err := structElems(tokenList)
+	assert.NoError(err)
+	assert.Equal([]interface{}{[]byte{1, 2}, []byte{3}}, uniqueSQLFieldValues(elems, "token_id"))
+	assert.NoError(b.LoadRelation(ctx, tokenList, "use_list"))
+	assert.Len(tokenList[0].UseList, 2)
+	assert.Len(tokenList[1].UseList, 0)
+	assert.Len(tokenList[2].UseList, 2)
+
+	assert.NoError(b.LoadRelationCount(ctx, tokenList))
+	assert.Equal(int64(2), tokenList[0].UseCount)
+	assert.Equal(int64(0), tokenList[1].UseCount)
+
+}
+
+func TestLoadRelationCount(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Author{
+			AuthorID:   fmt.Sprintf("author_%04d", i),
+			NomDePlume: fmt.Sprintf("Author %d", i),
+		}).Exec()))
+	}
+	assert.NoError(b.ExecOK(b.MustInsert([]Category{
+		{CategoryID: "category_0001", Name: "Science Fiction"},
+		{CategoryID: "category_0002", Name: "Adventure"},
+	})))
+
+	// author_0001 has two books, author_0002 has one, author_0003 has none
+	for i, authorID := range []string{"author_0001", "author_0001", "author_0002"} {
+		book := Book{
+			BookID:         fmt.Sprintf("book_%04d", i+1),
+			Title:          fmt.Sprintf("Book %d", i+1),
+			AuthorID:       authorID,
+			CategoryIDList: []string{"category_0001"},
+		}
+		if i == 0 {
+			book.CategoryIDList = append(book.CategoryIDList, "category_0002", "category_9999")
+		}
+		assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&book).Exec()))
+		assert.NoError(b.ExecOK(b.MustInsertRelationIgnore(&book, "category_id_list")))
+	}
+
+	// has many
+	authorList := []Author{{AuthorID: "author_0001"}, {AuthorID: "author_0002"}, {AuthorID: "author_0003", BookCount: 99}}
+	assert.NoError(b.LoadRelationCount(ctx, authorList))
+	assert.Equal(2, authorList[0].BookCount)
+	assert.Equal(1, authorList[1].BookCount)
+	assert.Equal(0, authorList[2].BookCount)
+
+	// belongs to many, with pointers, the category which doesn't exist is not counted
+	var bookList []*Book
+	_, err = b.MustSelect(&bookList).OrderAsc("book_id").Load(&bookList)
+	assert.NoError(err)
+	assert.NoError(b.LoadRelationCount(ctx, &bookList, "category_list"))
+	assert.Equal(int64(2), bookList[0].CategoryCount)
+	assert.Equal(int64(1), bookList[1].CategoryCount)
+
+	// belongs to many IDs counts the join table only
+	meta.For(Book{}).SetRelationCountField("category_id_list", "CategoryCount")
+	assert.NoError(b.LoadRelationCount(ctx, bookList[0], "category_id_list"))
+	assert.Equal(int64(3), bookList[0].CategoryCount)
+
+	// no count field
+	assert.Error(b.LoadRelationCount(ctx, &bookList, "author"))
+
+}
+
+func TestLoadRelationPath(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+	ctx := context.Background()
+
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Author{
+		AuthorID:   "author_0001",
+		NomDePlume: "Orson Scott Card",
+	}).Exec()))
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Category{
+		CategoryID: "category_0001",
+		Name:       "Science Fiction",
+	}).Exec()))
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&CategoryInfo{
+		CategoryID: "category_0001",
+		InfoStuff:  "info stuff value",
+	}).Exec()))
+	for i, title := range []string{"Ender's Game", "Speaker for the Dead"} {
+		book := Book{
+			BookID:         fmt.Sprintf("book_%04d", i+1),
+			Title:          title,
+			AuthorID:       "author_0001",
+			CategoryIDList: []string{"category_0001"},
+		}
+		assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&book).Exec()))
+		assert.NoError(b.ExecOK(b.MustInsertRelationIgnore(&book, "category_id_list")))
+	}
+
+	// has many -> belongs to many -> has one
+	var authorList []Author
+	_, err = b.MustSelect(&authorList).Load(&authorList)
+	assert.NoError(err)
+	assert.NoError(b.LoadRelation(ctx, authorList, "book_list.category_list.category_info"))
+	assert.Len(authorList[0].BookList, 2)
+	for _, book := range authorList[0].BookList {
+		assert.Len(book.CategoryList, 1)
+		assert.Equal("info stuff value", book.CategoryList[0].CategoryInfo.InfoStuff)
+	}
+
+	// belongs to -> has many
+	var bookList []Book
+	_, err = b.MustSelect(&bookList).Load(&bookList)
+	assert.NoError(err)
+	assert.NoError(b.LoadRelation(ctx, bookList, "author.book_list"))
+	assert.Len(bookList[0].Author.BookList, 2)
+
+	// invalid paths are caught before querying
+	assert.Error(b.LoadRelation(ctx, bookList, "author.not_a_relation"))
+	assert.Error(b.LoadRelation(ctx, bookList, "category_id_list.category_info"))
+
+}
+
+// UpsertTester has create and update times with a string primary key
+type UpsertTester struct {
+	UpsertTesterID string `db:"upsert_tester_id" tmeta:"pk"`
+	Name           string `db:"name"`
+	CreateTime     DBTime `db:"create_time" tmeta:"create_time"`
+	UpdateTime     DBTime `db:"update_time"`
+}
+
+func (ut *UpsertTester) CreateTimeTouch() { ut.CreateTime = NewDBTime() }
+func (ut *UpsertTester) UpdateTimeTouch() { ut.UpdateTime = NewDBTime() }
+
+// Gizmo implements hooks, recording which were called in Events
+type Gizmo struct {
+	GizmoID string   `db:"gizmo_id" tmeta:"pk"`
+	Name    string   `db:"name"`
+	Slug    string   `db:"slug"`
+	Events  []string `db:"-"`
+}
+
+type gizmoCtxKey struct{}
+
+func (g *Gizmo) BeforeInsert() error {
+	if g.Name == "" {
+		return errors.New("name is required")
+	}
+	g.Slug = strings.ToLower(strings.Replace(g.Name, " ", "-", -1))
+	g.Events = append(g.Events, "before_insert")
+	return nil
+}
+
+func (g *Gizmo) AfterInsertContext(ctx context.Context) error {
+	g.Events = append(g.Events, "after_insert")
+	return nil
+}
+
+func (g *Gizmo) BeforeUpdateContext(ctx context.Context) error {
+	g.Events = append(g.Events, fmt.Sprintf("before_update:%v", ctx.Value(gizmoCtxKey{})))
+	return nil
+}
+
+func (g *Gizmo) AfterUpdate() error {
+	g.Events = append(g.Events, "after_update")
+	return nil
+}
+
+func (g *Gizmo) AfterDelete() error {
+	g.Events = append(g.Events, "after_delete")
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sess.Exec(`
+CREATE TABLE gizmo (
+	gizmo_id VARCHAR(64),
+	name VARCHAR(255),
+	slug VARCHAR(255),
+	PRIMARY KEY(gizmo_id)
+)`)
+	assert.NoError(err)
+	meta.MustParse(Gizmo{})
+
+	// meta hooks are called after the record's own
+	errLocked := errors.New("gizmo is locked")
+	meta.AddHook("gizmo", tmeta.BeforeDelete, func(ctx context.Context, o interface{}) error {
+		if o.(*Gizmo).Name == "Locked" {
+			return errLocked
+		}
+		return nil
+	})
+	var inserted []string
+	meta.AddHook("gizmo", tmeta.AfterInsert, func(ctx context.Context, o interface{}) error {
+		inserted = append(inserted, o.(*Gizmo).GizmoID)
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), gizmoCtxKey{}, "ctxval")
+	b := New(sess, meta)
+
+	// before hook can veto
+	_, err = b.Insert(&Gizmo{GizmoID: "gizmo_0001"})
+	assert.EqualError(err, "name is required")
+
+	gizmo := Gizmo{GizmoID: "gizmo_0001", Name: "Big Gizmo"}
+	assert.NoError(b.InsertAndLoadIDs(ctx, &gizmo))
+	assert.Equal("big-gizmo", gizmo.Slug)
+	assert.Equal([]string{"before_insert", "after_insert"}, gizmo.Events)
+
+	gizmoList := []Gizmo{{GizmoID: "gizmo_0002", Name: "Locked"}, {GizmoID: "gizmo_0003", Name: "Small Gizmo"}}
+	assert.NoError(b.AfterInsertHooks(gizmoList, b.ExecOK(b.MustInsert(gizmoList))))
+	assert.Equal("small-gizmo", gizmoList[1].Slug)
+	assert.Equal([]string{"before_insert", "after_insert"}, gizmoList[1].Events)
+	assert.Equal([]string{"gizmo_0001", "gizmo_0002", "gizmo_0003"}, inserted)
+
+	var gizmo2 Gizmo
+	assert.NoError(b.MustSelectByID(&gizmo2, "gizmo_0003").LoadOne(&gizmo2))
+	assert.Equal("small-gizmo", gizmo2.Slug)
+
+	// context from WithContext
+	gizmo.Events = nil
+	bctx := b.WithContext(ctx)
+	assert.NoError(bctx.AfterUpdateHooks(&gizmo, bctx.ResultWithOneUpdate(bctx.MustUpdateByID(&gizmo).Exec())))
+	assert.Equal([]string{"before_update:ctxval", "after_update"}, gizmo.Events)
+
+	// struct values are copied for the hooks rather than panicking, the copy's values are used
+	gizmo2.Name = "Renamed Gizmo"
+	assert.NotPanics(func() { assert.NoError(b.ExecOK(b.MustUpdateByID(gizmo2))) })
+	var gizmo3 Gizmo
+	assert.NoError(b.MustSelectByID(&gizmo3, "gizmo_0003").LoadOne(&gizmo3))
+	assert.Equal("Renamed Gizmo", gizmo3.Name)
+
+	// upsert calls the before insert hooks too
+	_, err = b.Upsert(&Gizmo{GizmoID: "gizmo_0004"})
+	assert.EqualError(err, "name is required")
+	gizmo4 := Gizmo{GizmoID: "gizmo_0004", Name: "Upserted Gizmo"}
+	assert.NoError(b.ExecOK(b.MustUpsert(&gizmo4)))
+	assert.Equal([]string{"before_insert"}, gizmo4.Events)
+	var gizmo5 Gizmo
+	assert.NoError(b.MustSelectByID(&gizmo5, "gizmo_0004").LoadOne(&gizmo5))
+	assert.Equal("upserted-gizmo", gizmo5.Slug)
+
+	// after hooks are not called on failure
+	gizmo.Events = nil
+	assert.Equal(ErrUpdateFailed, b.AfterUpdateHooks(&gizmo, b.ResultWithOneUpdate(b.MustUpdateByID(&Gizmo{GizmoID: "gizmo_9999"}).Exec())))
+	assert.Len(gizmo.Events, 0)
+
+	// meta hook can veto
+	_, err = b.DeleteByID(&gizmoList[0])
+	assert.Equal(errLocked, err)
+
+	gizmo.Events = nil
+	assert.NoError(NewRepo[Gizmo](b).Delete(ctx, &gizmo))
+	assert.Equal([]string{"after_delete"}, gizmo.Events)
+
+}
+
+type Sprocket struct {
+	SprocketID string `db:"sprocket_id" tmeta:"pk"`
+	Name       string `db:"name" tmeta:"required,max_len=20"`
+	Teeth      int    `db:"teeth" tmeta:"min=3"`
+}
+
+func TestValidate(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sess.Exec(`
+CREATE TABLE sprocket (
+	sprocket_id VARCHAR(64),
+	name VARCHAR(20),
+	teeth INTEGER,
+	PRIMARY KEY(sprocket_id)
+)`)
+	assert.NoError(err)
+	meta.MustParse(Sprocket{})
+
+	b := New(sess, meta)
+
+	// nothing is built for an invalid record
+	_, err = b.Insert(&Sprocket{SprocketID: "sprocket_0001", Teeth: 1})
+	assert.Equal(map[string]string{"name": "is required", "teeth": "must be at least 3"}, err.(tmeta.ValidationErrors).Map())
+	_, err = b.Insert([]Sprocket{{SprocketID: "sprocket_0001", Name: "Small", Teeth: 8}, {SprocketID: "sprocket_0002", Teeth: 8}})
+	assert.Equal(map[string]string{"name": "is required"}, err.(tmeta.ValidationErrors).Map())
+
+	sprocket := Sprocket{SprocketID: "sprocket_0001", Name: "Small", Teeth: 8}
+	assert.NoError(b.ExecOK(b.MustInsert(&sprocket)))
+
+	sprocket.Name = "A Very Large Sprocket Indeed"
+	_, err = b.UpdateByID(&sprocket)
+	assert.Equal(map[string]string{"name": "must be at most 20 characters"}, err.(tmeta.ValidationErrors).Map())
+	_, err = b.Upsert(&sprocket)
+	assert.Error(err)
+
+	// the error code comes through the Repo
+	err = NewRepo[Sprocket](b).Update(context.Background(), &sprocket)
+	assert.Equal(400, err.(interface{ Code() int }).Code())
+
+}
+
+func TestUpsert(t *testing.T) {
+
+	assert := assert.New(t)
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(sess, meta)
+
+	// slice, mix of new and existing records
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Author{
+		AuthorID:   "author_0001",
+		NomDePlume: "Mark Twain",
+	}).Exec()))
+	authorList := []Author{
+		{AuthorID: "author_0001", NomDePlume: "Samuel Clemens"},
+		{AuthorID: "author_0002", NomDePlume: "Lewis Carroll"},
+	}
+	assert.NoError(b.ExecOK(b.MustUpsert(authorList)))
+	var authorList2 []Author
+	_, err = b.MustSelect(&authorList2).OrderAsc("author_id").Load(&authorList2)
+	assert.NoError(err)
+	assert.Len(authorList2, 2)
+	assert.Equal("Samuel Clemens", authorList2[0].NomDePlume)
+	assert.Equal("Lewis Carroll", authorList2[1].NomDePlume)
+
+	// empty slice needs no statement
+	stmt, err := b.Upsert([]Author{})
+	assert.NoError(err)
+	assert.Nil(stmt)
+
+	// version is incremented and checked on update
+	publisher := Publisher{PublisherID: "publisher_0001", CompanyName: "Tor"}
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpsert(&publisher).Exec()))
+	assert.Equal(int64(1), publisher.Version)
+	publisher.CompanyName = "Tor Books"
+	assert.NoError(b.ResultWithOneUpdate(b.MustUpsert(&publisher).Exec()))
+	assert.Equal(int64(2), publisher.Version)
+	stalePublisher := Publisher{PublisherID: "publisher_0001", CompanyName: "Stale", Version: 1}
+	assert.Equal(ErrUpdateFailed, b.ResultWithOneUpdate(b.MustUpsert(&stalePublisher).Exec()))
+	var publisher2 Publisher
+	assert.NoError(b.MustSelectByID(&publisher2, "publisher_0001").LoadOne(&publisher2))
+	assert.Equal("Tor Books", publisher2.CompanyName)
+	assert.Equal(int64(2), publisher2.Version)
+
+	// create time is only set on insert
+	_, err = sess.Exec(`
+CREATE TABLE upsert_tester (
+	upsert_tester_id VARCHAR(64),
+	name VARCHAR(255),
+	create_time TEXT,
+	update_time TEXT,
+	PRIMARY KEY(upsert_tester_id)
+)`)
+	assert.NoError(err)
+	meta.MustParse(UpsertTester{})
+	strictMeta := tmeta.NewMeta()
+	strictMeta.SetStrictTags(true)
+	assert.NoError(strictMeta.Parse(UpsertTester{})) // create_time is registered by tmetadbr
+	ut := UpsertTester{UpsertTesterID: "upsert_tester_0001", Name: "first"}
+	assert.NoError(b.ExecOK(b.MustUpsert(&ut)))
+	createTime := ut.CreateTime
+	time.Sleep(time.Millisecond)
+	ut.Name = "second"
+	assert.NoError(b.ExecOK(b.MustUpsert(&ut)))
+	var ut2 UpsertTester
+	assert.NoError(b.MustSelectByID(&ut2, "upsert_tester_0001").LoadOne(&ut2))
+	assert.Equal("second", ut2.Name)
+	assert.True(createTime.Equal(ut2.CreateTime.Time))
+	assert.True(ut2.UpdateTime.After(ut2.CreateTime.Time))
+
+	// auto increment not supported
+	_, err = b.Upsert(&CategoryInfo{})
+	assert.Error(err)
+
+}
+
+// Shelf has many Memos, which are soft deleted with a time
+type Shelf struct {
+	ShelfID  string `db:"shelf_id" tmeta:"pk"`
+	MemoList []Memo `db:"-" tmeta:"has_many"`
+}
+
+type Memo struct {
+	MemoID    string `db:"memo_id" tmeta:"pk"`
+	ShelfID   string `db:"shelf_id"`
+	Body      string `db:"body"`
+	DeletedAt DBTime `db:"deleted_at" tmeta:"soft_delete"`
+}
+
+// Sticker is soft deleted with a bool
+type Sticker struct {
+	StickerID string `db:"sticker_id" tmeta:"pk"`
+	Deleted   bool   `db:"deleted" tmeta:"soft_delete"`
+}
+
+func TestSoftDelete(t *testing.T) {
+
+	assert := assert.New(t)
+	ctx := context.Background()
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{`
+CREATE TABLE shelf (
+	shelf_id VARCHAR(64),
+	PRIMARY KEY(shelf_id)
+)`, `
+CREATE TABLE memo (
+	memo_id VARCHAR(64),
+	shelf_id VARCHAR(64),
+	body VARCHAR(255),
+	deleted_at TEXT,
+	PRIMARY KEY(memo_id)
+)`, `
+CREATE TABLE sticker (
+	sticker_id VARCHAR(64),
+	deleted INTEGER NOT NULL,
+	PRIMARY KEY(sticker_id)
+)`} {
+		_, err = sess.Exec(s)
+		assert.NoError(err)
+	}
+	meta.MustParse(Shelf{})
+	meta.MustParse(Memo{})
+	meta.MustParse(Sticker{})
+	assert.Equal("deleted_at", meta.For(Memo{}).SQLSoftDeleteField())
+
+	b := New(sess, meta)
+
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Shelf{ShelfID: "shelf_0001"}).Exec()))
+	assert.NoError(b.ExecOK(b.MustInsert([]Memo{
+		{MemoID: "memo_0001", ShelfID: "shelf_0001", Body: "keep"},
+		{MemoID: "memo_0002", ShelfID: "shelf_0001", Body: "discard"},
+	})))
+
+	// delete marks the record instead of removing it, and only once
+	_, err = b.DeleteByID(Memo{}, "memo_0002")
+	assert.Error(err) // would really delete it
+	assert.NoError(b.ResultWithOneUpdate(b.MustSoftDeleteByID(Memo{}, "memo_0002").Exec()))
+	assert.Equal(ErrUpdateFailed, b.ResultWithOneUpdate(b.MustSoftDeleteByID(Memo{}, "memo_0002").Exec()))
+
+	// a Where added to the statement still applies
+	assert.Equal(ErrUpdateFailed, b.ResultWithOneUpdate(b.MustSoftDeleteByID(Memo{}, "memo_0001").Where("shelf_id = ?", "shelf_9999").Exec()))
+	var n int
+	assert.NoError(sess.Select("COUNT(*)").From("memo").LoadOne(&n))
+	assert.Equal(2, n)
+
+	var memoList []Memo
+	_, err = b.MustSelect(&memoList).Load(&memoList)
+	assert.NoError(err)
+	assert.Len(memoList, 1)
+	assert.Equal("memo_0001", memoList[0].MemoID)
+
+	var memo Memo
+	assert.Equal(dbr.ErrNotFound, b.MustSelectByID(&memo, "memo_0002").LoadOne(&memo))
+
+	shelf := Shelf{ShelfID: "shelf_0001"}
+	stmt, ptr := b.MustSelectRelationPtr(&shelf, "memo_list")
+	_, err = stmt.Load(ptr)
+	assert.NoError(err)
+	assert.Len(shelf.MemoList, 1)
+
+	shelfList := []Shelf{shelf}
+	assert.NoError(b.LoadRelation(ctx, shelfList, "memo_list"))
+	assert.Len(shelfList[0].MemoList, 1)
+
+	// unless asked for
+	b.IncludeDeleted = true
+	assert.NoError(b.MustSelectByID(&memo, "memo_0002").LoadOne(&memo))
+	assert.False(memo.DeletedAt.IsZero())
+	assert.NoError(b.LoadRelation(ctx, shelfList, "memo_list"))
+	assert.Len(shelfList[0].MemoList, 2)
+	b.IncludeDeleted = false
+
+	// bool field
+	assert.NoError(b.ResultWithOneUpdate(b.MustInsert(&Sticker{StickerID: "sticker_0001"}).Exec()))
+	var sticker Sticker
+	assert.NoError(b.MustSelectByID(&sticker, "sticker_0001").LoadOne(&sticker))
+	assert.NoError(NewRepo[Sticker](b).Delete(ctx, &sticker))
+	assert.Equal(dbr.ErrNotFound, b.MustSelectByID(&sticker, "sticker_0001").LoadOne(&sticker))
+
+}
+
 // func NewDBNanoTime() DBNanoTime {
 // 	return DBNanoTime{Time: time.Now()}
 // }
@@ -505,3 +1811,81 @@ func TestPostgres(t *testing.T) {
 	}
 	t.Logf("TODO: Postgres-specific testing")
 }
+
+func benchmarkBookList(b *testing.B) (*Builder, []Book) {
+	sess, meta, err := doSetup("sqlite3")
+	if err != nil {
+		b.Fatal(err)
+	}
+	bookList := make([]Book, 1000)
+	for i := range bookList {
+		bookList[i] = Book{BookID: fmt.Sprintf("book_%04d", i), AuthorID: "author_0001", PublisherID: "publisher_0001", Title: "Les Misérables"}
+	}
+	return New(sess, meta), bookList
+}
+
+// recordInsert and reflectUpsert build the same statements as Insert and Upsert did before the
+// field information was precomputed, with dbr's Record (which reflects over each record) and
+// sqlFieldValue for each field, kept as a baseline for the benchmarks.  Hooks and validation are
+// left out, so the baseline comes out slightly ahead on anything else.
+
+func recordInsert(bb *Builder, bookList []Book) *dbr.InsertStmt {
+	ti := bb.Meta.For(Book{})
+	stmt := bb.Session.InsertInto(ti.SQLName()).Columns(ti.SQLFields(true)...)
+	for i := range bookList {
+		stmt = stmt.Record(&bookList[i])
+	}
+	return stmt
+}
+
+func reflectUpsert(bb *Builder, bookList []Book) *dbr.InsertStmt {
+	ti := bb.Meta.For(Book{})
+	sqlFields := ti.SQLFields(true)
+	var buf bytes.Buffer
+	var args []interface{}
+	for i := range bookList {
+		elv := reflect.ValueOf(&bookList[i]).Elem()
+		buf.WriteString(`(` + strings.TrimSuffix(strings.Repeat(`?,`, len(sqlFields)), `,`) + `),`)
+		for _, f := range sqlFields {
+			args = append(args, sqlFieldValue(elv, f))
+		}
+	}
+	d, _ := bb.dialect()
+	q, _ := bb.quoter()
+	return bb.Session.InsertBySql(
+		d.UpsertSQL(q(ti.SQLName()), quoteList(q, sqlFields), strings.TrimSuffix(buf.String(), ","),
+			quoteList(q, ti.SQLPKFields()), quoteList(q, ti.SQLFields(false)), ""),
+		args...)
+}
+
+func BenchmarkInsert(b *testing.B) {
+	bb, bookList := benchmarkBookList(b)
+	b.Run("record", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			recordInsert(bb, bookList)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bb.MustInsert(bookList)
+		}
+	})
+}
+
+func BenchmarkUpsert(b *testing.B) {
+	bb, bookList := benchmarkBookList(b)
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectUpsert(bb, bookList)
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bb.MustUpsert(bookList)
+		}
+	})
+}
@@ -14,7 +14,8 @@ type Author struct {
 	AuthorID   string `db:"author_id" tmeta:"pk"`
 	NomDePlume string `db:"nom_de_plume"`
 
-	BookList []Book `db:"-" tmeta:"has_many"`
+	BookList  []Book `db:"-" tmeta:"has_many"`
+	BookCount int    `db:"-" tmeta:"count_of=book_list"`
 }
 
 type Publisher struct {
@@ -25,6 +26,8 @@ type Publisher struct {
 	BookList []Book `db:"-" tmeta:"has_many,relation_name=book_list"`
 }
 
+func (p *Publisher) VersionIncrement() { p.Version++ }
+
 type Book struct {
 	BookID string `db:"book_id" tmeta:"pk"`
 
@@ -36,7 +39,8 @@ type Book struct {
 
 	Title string `db:"title"`
 
-	CategoryList []Category `db:"-" tmeta:"belongs_to_many,join_name=book_category"`
+	CategoryList  []Category `db:"-" tmeta:"belongs_to_many,join_name=book_category"`
+	CategoryCount int64      `db:"-" tmeta:"count_of=category_list"`
 
 	CategoryIDList []string `db:"-" tmeta:"belongs_to_many_ids,join_name=book_category"`
 }
@@ -0,0 +1,114 @@
+package tmetadbr
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+)
+
+// MustUpdateChanged is the same as UpdateChanged but panics on error.
+func (b *Builder) MustUpdateChanged(orig, o interface{}) *dbr.UpdateStmt {
+	ret, err := b.UpdateChanged(orig, o)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// UpdateChanged is like UpdateByID but only sets the fields which are different between orig
+// (a copy of the record as it was selected) and o (the record with changes made to it),
+// so concurrent updates to other fields of the same record are not overwritten.
+// The primary key of o is used.  Optimistic locking works the same as with UpdateByID, the
+// version field of o should be the one it was selected with and is incremented.
+// UpdateTimeTouch is called on o only if other fields have changed.  The BeforeUpdate hooks
+// are called first, so changes they make are included, then o is validated (see tmeta.TableInfo.Validate).
+// Note: (nil,nil) is a valid return in cases where no fields have changed, indicating
+// that no update is necessary.
+func (b *Builder) UpdateChanged(orig, o interface{}) (*dbr.UpdateStmt, error) {
+
+	ti := b.Meta.For(o)
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+	if derefType(reflect.TypeOf(orig)) != ti.GoType() {
+		return nil, fmt.Errorf("UpdateChanged requires orig and o to be the same type, got %T and %T", orig, o)
+	}
+
+	// a struct value is copied so hooks have a pointer, changes they make are still used below
+	po := hookPtr(o)
+
+	err := b.runHooks(po, tmeta.BeforeUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ti.Validate(po)
+	if err != nil {
+		return nil, err
+	}
+
+	origMap := ti.SQLValueMap(orig, false)
+	if len(changedSQLFields(origMap, ti.SQLValueMap(po, false), ti.SQLVersionField())) == 0 {
+		return nil, nil
+	}
+
+	// touch the update time if possible
+	if ctt, ok := po.(UpdateTimeToucher); ok {
+		ctt.UpdateTimeTouch()
+	}
+
+	vmap := ti.SQLValueMap(po, false)
+	setMap := make(map[string]interface{})
+	for _, f := range changedSQLFields(origMap, vmap, ti.SQLVersionField()) {
+		setMap[f] = vmap[f]
+	}
+
+	// extract and increment version value
+	var curVer interface{}
+	if ti.SQLVersionField() != "" {
+		curVer = vmap[ti.SQLVersionField()]
+		vi, ok := po.(VersionIncrementer)
+		if !ok {
+			return nil, fmt.Errorf("SQLVersionField is set to %q but VersionIncrement not implemented for type %T", ti.SQLVersionField(), o)
+		}
+		vi.VersionIncrement()
+		setMap[ti.SQLVersionField()] = ti.SQLValueMap(po, false)[ti.SQLVersionField()]
+	}
+
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: dbr quotes the table and SetMap field names itself, see stmtDialect
+	ustmt := b.Session.
+		Update(ti.SQLName()).
+		SetMap(setMap).
+		Where(ti.SQLPKWhereQuoted(q), ti.PKValues(o)...)
+	ustmt.Dialect = b.stmtDialect(ustmt.Dialect)
+
+	if ti.SQLVersionField() != "" { // optimistic lock prevents updating record with newer version
+		ustmt = ustmt.Where(q(ti.SQLVersionField())+" = ?", curVer)
+	}
+
+	return ustmt, nil
+}
+
+// changedSQLFields returns the sorted names of the fields whose values differ between
+// the two value maps, ignoring sqlVersionField.
+func changedSQLFields(origMap, newMap map[string]interface{}, sqlVersionField string) []string {
+	var ret []string
+	for f, v := range newMap {
+		if f == sqlVersionField {
+			continue
+		}
+		if !reflect.DeepEqual(origMap[f], v) {
+			ret = append(ret, f)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
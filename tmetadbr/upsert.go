@@ -0,0 +1,129 @@
+package tmetadbr
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+)
+
+func init() {
+	tmeta.RegisterTagOptions("create_time")
+}
+
+// MustUpsert is the same as Upsert but panics on error.
+func (b *Builder) MustUpsert(o interface{}) *dbr.InsertStmt {
+	ret, err := b.Upsert(o)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Upsert generates an insert statement for the object(s) provided which updates the
+// existing record instead if one with the same primary key already exists, using
+// the Dialect's UpsertSQL.  Slice is supported.
+// Like Insert, IDAssign, CreateTimeTouch and UpdateTimeTouch are called on the object(s)
+// if possible, followed by the BeforeInsert hooks (whether the record ends up being inserted
+// or updated), and each record is validated (see tmeta.TableInfo.Validate).  When a record is updated, fields
+// tagged `tmeta:"create_time"` are left as they are in the database.
+//
+// If SQLVersionField is set, VersionIncrement is called on the object(s) and an existing
+// record is only updated if it's version is the one before that (i.e. the one the object
+// was selected with), otherwise it is left unchanged and is not counted in RowsAffected.
+// This requires the version field to be numeric.
+//
+// Tables with an auto increment primary key are not supported.
+// Note: (nil,nil) is a valid return in cases where an empty slice is provided,
+// indicating that no insert is necessary.
+func (b *Builder) Upsert(o interface{}) (*dbr.InsertStmt, error) {
+
+	ti := b.Meta.ForType(elemDerefType(reflect.TypeOf(o)))
+	if ti == nil {
+		return nil, ErrTypeNotRegistered
+	}
+
+	if ti.PKAutoIncr() {
+		return nil, fmt.Errorf("Upsert is not supported for %v because it has an auto increment primary key", ti.GoType())
+	}
+
+	elems, err := structElems(o)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, nil
+	}
+
+	sqlFields := ti.SQLFields(true)
+
+	// build a buffer with the SQL values placeholders, and also the args to pass
+	var buf bytes.Buffer
+	args := make([]interface{}, 0, len(elems)*len(sqlFields))
+	for _, elv := range elems {
+		el := elv.Addr().Interface()
+		// id assign if possible
+		if ida, ok := el.(IDAssigner); ok {
+			ida.IDAssign()
+		}
+		// touch create time if possible
+		if ctt, ok := el.(CreateTimeToucher); ok {
+			ctt.CreateTimeTouch()
+		}
+		// touch update time if possible
+		if ctt, ok := el.(UpdateTimeToucher); ok {
+			ctt.UpdateTimeTouch()
+		}
+		// the values are read below, so hooks must be called first
+		err := b.runElemHooks(ti, el, tmeta.BeforeInsert)
+		if err != nil {
+			return nil, err
+		}
+		err = ti.Validate(el)
+		if err != nil {
+			return nil, err
+		}
+		if ti.SQLVersionField() != "" {
+			vi, ok := el.(VersionIncrementer)
+			if !ok {
+				return nil, fmt.Errorf("SQLVersionField is set to %q but VersionIncrement not implemented for type %T", ti.SQLVersionField(), el)
+			}
+			vi.VersionIncrement()
+		}
+		buf.WriteString(`(` + strings.TrimSuffix(strings.Repeat(`?,`, len(sqlFields)), `,`) + `),`)
+		args = ti.AppendSQLValues(args, el, true)
+	}
+	var valueStr = strings.TrimSuffix(buf.String(), ",")
+
+	// the fields to update when the record exists
+	var updateFields []string
+	for _, fi := range ti.Fields() {
+		if fi.IsPK || fi.IsVersion || len(fi.Options["create_time"]) > 0 {
+			continue
+		}
+		updateFields = append(updateFields, fi.SQLName)
+	}
+
+	d, err := b.dialect()
+	if err != nil {
+		return nil, err
+	}
+	q, err := b.quoter()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlVersionField := ti.SQLVersionField()
+	if sqlVersionField != "" {
+		sqlVersionField = q(sqlVersionField)
+	}
+
+	return b.Session.InsertBySql(
+			d.UpsertSQL(q(ti.SQLName()), quoteList(q, sqlFields), valueStr,
+				quoteList(q, ti.SQLPKFields()), quoteList(q, updateFields), sqlVersionField),
+			args...),
+		nil
+}
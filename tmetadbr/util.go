@@ -7,6 +7,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
 )
 
 // IDAssigner can be implemented by objects to provide a means to "set the ID to a new value if not already done".
@@ -124,10 +127,12 @@ func elemDerefType(t reflect.Type) reflect.Type {
 type errorWithCode struct {
 	code int
 	msg  string
+	is   error // more general error this one is a case of, for errors.Is
 }
 
-func (ec *errorWithCode) Error() string { return ec.msg }
-func (ec *errorWithCode) Code() int     { return ec.code }
+func (ec *errorWithCode) Error() string        { return ec.msg }
+func (ec *errorWithCode) Code() int            { return ec.code }
+func (ec *errorWithCode) Is(target error) bool { return ec.is != nil && ec.is == target }
 
 // walks all exported fields, including embedded anonymous structs and returns a slice
 // of index slices for use with reflect.Type.FieldByIndex
@@ -168,22 +173,20 @@ type sqlFieldIndexCacheKey struct {
 	F string
 }
 
-var sqlFieldIndexCacheMU sync.RWMutex
-var sqlFieldIndexCache = make(map[sqlFieldIndexCacheKey][]int)
+// sqlFieldIndexCache is a map of sqlFieldIndexCacheKey to []int, the entries never change once
+// written so a sync.Map avoids taking a global lock while the fields are scanned
+var sqlFieldIndexCache sync.Map
 
+// sqlFieldIndex returns the index of the field with the SQL name given in any struct type,
+// where the TableInfo is available use its FieldBySQLName instead.
 func sqlFieldIndex(t reflect.Type, sqlFieldName string) []int {
 
-	sqlFieldIndexCacheMU.RLock()
-	ret, ok := sqlFieldIndexCache[sqlFieldIndexCacheKey{T: t, F: sqlFieldName}]
-	sqlFieldIndexCacheMU.RUnlock()
-
-	if ok {
-		return ret
+	key := sqlFieldIndexCacheKey{T: t, F: sqlFieldName}
+	if ret, ok := sqlFieldIndexCache.Load(key); ok {
+		return ret.([]int)
 	}
 
-	sqlFieldIndexCacheMU.Lock()
-	defer sqlFieldIndexCacheMU.Unlock()
-
+	var ret []int
 	for _, idx := range exportedFieldIndexes(t) {
 		sf := t.FieldByIndex(idx)
 		sfdb := strings.SplitN(sf.Tag.Get("db"), ",", 2)[0]
@@ -197,7 +200,7 @@ func sqlFieldIndex(t reflect.Type, sqlFieldName string) []int {
 	}
 
 	// write result to cache (might be nil, but that's okay to cache also)
-	sqlFieldIndexCache[sqlFieldIndexCacheKey{T: t, F: sqlFieldName}] = ret
+	sqlFieldIndexCache.Store(key, ret)
 
 	return ret
 }
@@ -218,6 +221,14 @@ func isZero(x interface{}) bool {
 	return reflect.DeepEqual(x, reflect.Zero(reflect.TypeOf(x)).Interface())
 }
 
+func quoteList(quote func(string) string, slist []string) []string {
+	ret := make([]string, 0, len(slist))
+	for _, s := range slist {
+		ret = append(ret, quote(s))
+	}
+	return ret
+}
+
 func stringsAddPrefix(slist []string, prefix string) []string {
 	ret := make([]string, 0, len(slist))
 	for _, s := range slist {
@@ -305,3 +316,32 @@ func (r *printEventReceiver) TimingKv(eventName string, nanoseconds int64, kvs m
 	r.Print(eventName, ": ", kvs, ": timing: ", time.Duration(nanoseconds))
 
 }
+
+// usesRecordID returns true if dbr's InsertStmt.Record would set RecordID for records of the type
+// ti describes, which it does for an int64 field it names "id" (from the db tag, or the Go field
+// name ID or Id if there is none).  Insert only calls Record for these, see recordValues.
+func usesRecordID(ti *tmeta.TableInfo) bool {
+	for _, fi := range ti.Fields() {
+		if fi.SQLName == "id" {
+			return fi.GoType.Kind() == reflect.Int64
+		}
+	}
+	// untagged fields aren't in Fields but dbr still maps them
+	t := ti.GoType()
+	for _, name := range []string{"ID", "Id"} {
+		if sf, ok := t.FieldByName(name); ok && sf.Tag.Get("db") == "" && sf.Type.Kind() == reflect.Int64 {
+			return true
+		}
+	}
+	return false
+}
+
+// recordValues adds the values for el (a struct pointer) to stmt, using the accessors precomputed
+// by TableInfo instead of dbr's Record which reflects over the struct each time, unless
+// recordID is true (see usesRecordID).
+func recordValues(stmt *dbr.InsertStmt, ti *tmeta.TableInfo, el interface{}, recordID bool) *dbr.InsertStmt {
+	if recordID {
+		return stmt.Record(el)
+	}
+	return stmt.Values(ti.SQLValues(el, !ti.PKAutoIncr())...)
+}
@@ -0,0 +1,272 @@
+package tmetaddl
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gocaveman/tmeta"
+)
+
+// Queryer is implemented by *sql.DB, *sql.Tx, *dbr.Session and *dbr.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// MismatchKind describes the type of difference found between a table and the database.
+type MismatchKind string
+
+const (
+	MissingTable     MismatchKind = "missing_table"      // table does not exist in the database
+	MissingField     MismatchKind = "missing_field"      // field is on the struct but not in the database table
+	ExtraField       MismatchKind = "extra_field"        // field is in the database table but not on the struct
+	PKMismatch       MismatchKind = "pk_mismatch"        // primary key fields differ
+	MissingJoinTable MismatchKind = "missing_join_table" // unregistered join table used by a relation does not exist
+)
+
+// Mismatch is a single difference between a table and the database.
+type Mismatch struct {
+	Kind     MismatchKind
+	SQLTable string
+	SQLField string // empty for table-level mismatches
+	Message  string
+}
+
+func (m Mismatch) String() string {
+	if m.SQLField != "" {
+		return fmt.Sprintf("%s: %s.%s: %s", m.Kind, m.SQLTable, m.SQLField, m.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", m.Kind, m.SQLTable, m.Message)
+}
+
+// MismatchList is the result of Check.
+type MismatchList []Mismatch
+
+// Err returns nil if the list is empty, otherwise an error describing each Mismatch.
+func (ml MismatchList) Err() error {
+	if len(ml) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tmetaddl: %d schema mismatch(es) found:", len(ml))
+	for _, m := range ml {
+		buf.WriteString("\n\t")
+		buf.WriteString(m.String())
+	}
+	return errors.New(buf.String())
+}
+
+// MustCheck is the same as Check but panics on error or if any mismatch is found.
+// Intended to be called at startup or in tests.
+func (g *Generator) MustCheck(ctx context.Context, db Queryer) {
+	ml, err := g.Check(ctx, db)
+	if err != nil {
+		panic(err)
+	}
+	if err := ml.Err(); err != nil {
+		panic(err)
+	}
+}
+
+// Check inspects the database and compares each table in the Meta with what is there, reporting
+// missing tables, missing or extra fields and primary key differences.  Join tables referred to
+// by a BelongsToMany or BelongsToManyIDs relation but not registered are checked for existence
+// and for the two ID fields.  A non-nil error is only returned if the database could not be
+// inspected, schema differences are reported in the MismatchList.  Only the included SQLite3,
+// MySQL and Postgres dialects are supported.
+func (g *Generator) Check(ctx context.Context, db Queryer) (MismatchList, error) {
+
+	var ret MismatchList
+
+	for _, name := range g.Meta.Names() {
+
+		ti := g.Meta.ForName(name)
+
+		dbFields, dbPKFields, err := g.describeTable(ctx, db, ti.SQLName())
+		if err != nil {
+			return nil, err
+		}
+
+		if dbFields == nil {
+			ret = append(ret, Mismatch{Kind: MissingTable, SQLTable: ti.SQLName(),
+				Message: fmt.Sprintf("table for %q does not exist", ti.Name())})
+			continue
+		}
+
+		sqlFields := ti.SQLFields(true)
+		for _, f := range sqlFields {
+			if !contains(dbFields, f) {
+				ret = append(ret, Mismatch{Kind: MissingField, SQLTable: ti.SQLName(), SQLField: f,
+					Message: fmt.Sprintf("field does not exist in database (Go type %v)", ti.GoType())})
+			}
+		}
+		for _, f := range dbFields {
+			if !contains(sqlFields, f) {
+				ret = append(ret, Mismatch{Kind: ExtraField, SQLTable: ti.SQLName(), SQLField: f,
+					Message: fmt.Sprintf("field is not mapped on Go type %v", ti.GoType())})
+			}
+		}
+
+		if !sameSet(ti.SQLPKFields(), dbPKFields) {
+			ret = append(ret, Mismatch{Kind: PKMismatch, SQLTable: ti.SQLName(),
+				Message: fmt.Sprintf("expected primary key %v but database has %v", ti.SQLPKFields(), dbPKFields)})
+		}
+
+	}
+
+	done := make(map[string]bool)
+	for _, name := range g.Meta.Names() {
+		ti := g.Meta.ForName(name)
+		for _, rn := range relationNames(ti) {
+
+			var joinName, sqlIDField, sqlOtherIDField string
+			switch r := ti.RelationNamed(rn).(type) {
+			case *tmeta.BelongsToMany:
+				joinName, sqlIDField, sqlOtherIDField = r.JoinName, r.SQLIDField, r.SQLOtherIDField
+			case *tmeta.BelongsToManyIDs:
+				joinName, sqlIDField, sqlOtherIDField = r.JoinName, r.SQLIDField, r.SQLOtherIDField
+			default:
+				continue
+			}
+
+			// registered join tables are checked above
+			if done[joinName] || g.Meta.ForName(joinName) != nil {
+				continue
+			}
+			done[joinName] = true
+			sqlJoinName := g.Meta.ReplacedSQLName(joinName)
+
+			dbFields, _, err := g.describeTable(ctx, db, sqlJoinName)
+			if err != nil {
+				return nil, err
+			}
+			if dbFields == nil {
+				ret = append(ret, Mismatch{Kind: MissingJoinTable, SQLTable: sqlJoinName,
+					Message: fmt.Sprintf("join table for relation %q on %q does not exist", rn, ti.Name())})
+				continue
+			}
+			for _, f := range []string{sqlIDField, sqlOtherIDField} {
+				if !contains(dbFields, f) {
+					ret = append(ret, Mismatch{Kind: MissingField, SQLTable: sqlJoinName, SQLField: f,
+						Message: fmt.Sprintf("join table field for relation %q on %q does not exist", rn, ti.Name())})
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// describeTable returns the fields and primary key fields of a table from the database.
+// If the table does not exist, nil fields are returned.
+// Only the included dialects are supported, by name.
+func (g *Generator) describeTable(ctx context.Context, db Queryer, sqlTable string) (sqlFields, sqlPKFields []string, reterr error) {
+
+	d, err := g.dialect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch d.Name() {
+
+	case tmeta.SQLite3Dialect{}.Name():
+		// PRAGMA doesn't support placeholders, table name must be quoted instead
+		rows, err := db.QueryContext(ctx, `PRAGMA table_info(`+g.quoteIdent(sqlTable)+`)`)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, typ string
+			var dflt sql.NullString
+			if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+				return nil, nil, err
+			}
+			sqlFields = append(sqlFields, name)
+			if pk > 0 {
+				sqlPKFields = append(sqlPKFields, name)
+			}
+		}
+		return sqlFields, sqlPKFields, rows.Err()
+
+	case tmeta.MySQLDialect{}.Name():
+		rows, err := db.QueryContext(ctx, `SELECT column_name, column_key FROM information_schema.columns
+WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position`, sqlTable)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name, key string
+			if err := rows.Scan(&name, &key); err != nil {
+				return nil, nil, err
+			}
+			sqlFields = append(sqlFields, name)
+			if key == "PRI" {
+				sqlPKFields = append(sqlPKFields, name)
+			}
+		}
+		return sqlFields, sqlPKFields, rows.Err()
+
+	case tmeta.PostgresDialect{}.Name():
+		sqlFields, err := queryStrings(ctx, db, `SELECT column_name FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, sqlTable)
+		if err != nil || sqlFields == nil {
+			return nil, nil, err
+		}
+		sqlPKFields, err := queryStrings(ctx, db, `SELECT kcu.column_name FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema
+WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1
+ORDER BY kcu.ordinal_position`, sqlTable)
+		return sqlFields, sqlPKFields, err
+
+	}
+
+	return nil, nil, fmt.Errorf("tmetaddl: Check does not support dialect %q", d.Name())
+}
+
+func queryStrings(ctx context.Context, db Queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	return ret, rows.Err()
+}
+
+func contains(sl []string, s string) bool {
+	for _, v := range sl {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package tmetaddl
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+
+	assert := assert.New(t)
+
+	meta := newTestMeta()
+	g := New(meta, tmeta.SQLite3Dialect{})
+	ctx := context.Background()
+
+	conn, err := dbr.Open("sqlite3", fmt.Sprintf(`file:tmetaddl_check_test%d?mode=memory&cache=shared`, rand.Int31()), nil)
+	assert.NoError(err)
+	defer conn.Close()
+
+	// empty database, everything is missing
+	ml, err := g.Check(ctx, conn)
+	assert.NoError(err)
+	assert.Len(ml, len(meta.Names()))
+	assert.Error(ml.Err())
+
+	for _, stmt := range g.MustCreateSQL() {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+
+	// freshly created schema matches
+	ml, err = g.Check(ctx, conn)
+	assert.NoError(err)
+	assert.Empty(ml)
+	assert.NoError(ml.Err())
+	assert.NotPanics(func() { g.MustCheck(ctx, conn) })
+
+	// now introduce some drift
+	for _, stmt := range []string{
+		`ALTER TABLE "test_author" ADD COLUMN "extra_stuff" TEXT`,
+		`DROP TABLE "test_category_info"`,
+		`CREATE TABLE "test_category_info" ("category_info_id" INTEGER, "category_id" TEXT)`,
+		`DROP TABLE "test_book_category"`,
+	} {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+
+	ml, err = g.Check(ctx, conn)
+	assert.NoError(err)
+	for _, m := range ml {
+		t.Logf("%v", m)
+	}
+	assert.Contains(ml, Mismatch{Kind: ExtraField, SQLTable: "test_author", SQLField: "extra_stuff",
+		Message: "field is not mapped on Go type tmetaddl.Author"})
+	assert.Contains(ml, Mismatch{Kind: MissingField, SQLTable: "test_category_info", SQLField: "info_stuff",
+		Message: "field does not exist in database (Go type tmetaddl.CategoryInfo)"})
+	assert.Contains(ml, Mismatch{Kind: PKMismatch, SQLTable: "test_category_info",
+		Message: "expected primary key [category_info_id] but database has []"})
+	assert.Contains(ml, Mismatch{Kind: MissingTable, SQLTable: "test_book_category",
+		Message: `table for "book_category" does not exist`})
+	assert.Len(ml, 4)
+	assert.Panics(func() { g.MustCheck(ctx, conn) })
+
+	// unregistered join tables are checked for existence and their ID fields
+	meta2 := tmeta.NewMeta()
+	meta2.MustParse(&Book{})
+	meta2.MustParse(&Category{})
+	meta2.ReplaceSQLNames(func(name string) string { return "test2_" + name })
+	g2 := New(meta2, tmeta.SQLite3Dialect{})
+	ml, err = g2.Check(ctx, conn)
+	assert.NoError(err)
+	assert.Contains(ml, Mismatch{Kind: MissingJoinTable, SQLTable: "test2_book_category",
+		Message: `join table for relation "category_id_list" on "book" does not exist`})
+	_, err = conn.Exec(`CREATE TABLE "test2_book_category" ("book_id" TEXT, PRIMARY KEY ("book_id"))`)
+	assert.NoError(err)
+	ml, err = g2.Check(ctx, conn)
+	assert.NoError(err)
+	assert.Contains(ml, Mismatch{Kind: MissingField, SQLTable: "test2_book_category", SQLField: "category_id",
+		Message: `join table field for relation "category_id_list" on "book" does not exist`})
+	assert.NotContains(ml, Mismatch{Kind: MissingJoinTable, SQLTable: "test2_book_category",
+		Message: `join table for relation "category_id_list" on "book" does not exist`})
+
+}
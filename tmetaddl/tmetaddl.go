@@ -0,0 +1,325 @@
+// Generate DDL (CREATE TABLE, CREATE INDEX) from tmeta table information.
+//
+// The Go type of each field is used to pick an appropriate SQL type with the dialect's SQLType,
+// along with the `pk`, `auto_incr` and `version` tags that tmeta already understands.
+// The following additional options are recognized in the tmeta struct tag:
+//
+//	sql_type=TYPE   use this SQL type instead of the one derived from the Go type
+//	not_null        add a NOT NULL constraint (always done for primary keys and version fields)
+//	default=VALUE   add a DEFAULT clause, VALUE is a SQL expression and is written as-is
+//	index           add an index on this field, index=NAME will combine fields with the same NAME into one index
+//	unique          add a unique index on this field, unique=NAME will combine fields with the same NAME into one index
+//
+// Example:
+//
+//	type Widget struct {
+//		WidgetID string          `db:"widget_id" tmeta:"pk"`
+//		Name     string          `db:"name" tmeta:"not_null,unique"`
+//		Price    string          `db:"price" tmeta:"sql_type=DECIMAL(10,2),default=0"`
+//		Version  int64           `db:"version" tmeta:"version"`
+//	}
+package tmetaddl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+)
+
+func init() {
+	tmeta.RegisterTagOptions("sql_type", "not_null", "default", "index", "unique")
+}
+
+// New returns a new Generator.  If dialect is nil the Meta's Dialect is used.
+func New(meta *tmeta.Meta, dialect tmeta.Dialect) *Generator {
+	return &Generator{
+		Meta:    meta,
+		Dialect: dialect,
+	}
+}
+
+// Generator makes DDL statements for the tables in a Meta.
+type Generator struct {
+	*tmeta.Meta
+	Dialect tmeta.Dialect // the database the DDL is for, if nil the Meta's Dialect is used
+}
+
+// MustCreateSQL is the same as CreateSQL but panics on error.
+func (g *Generator) MustCreateSQL() []string {
+	ret, err := g.CreateSQL()
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// CreateSQL returns the CREATE TABLE and CREATE INDEX statements for every table in the Meta,
+// followed by any join tables which are referred to by a BelongsToMany or BelongsToManyIDs
+// relation but are not themselves registered.  These have the two ID fields as a composite primary key
+// and are named with the Meta's ReplaceSQLNames functions applied, the same as a registered table.
+// Each string is one statement, without a trailing semicolon.
+func (g *Generator) CreateSQL() ([]string, error) {
+
+	var ret []string
+
+	for _, name := range g.Meta.Names() {
+		stmts, err := g.CreateTableSQL(g.Meta.ForName(name))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, stmts...)
+	}
+
+	joinNames := make(map[string]bool)
+	for _, name := range g.Meta.Names() {
+		ti := g.Meta.ForName(name)
+		for _, rn := range relationNames(ti) {
+			stmts, err := g.joinTableSQL(ti, ti.RelationNamed(rn), joinNames)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, stmts...)
+		}
+	}
+
+	return ret, nil
+}
+
+// DropSQL returns a DROP TABLE statement for each table that CreateSQL would create,
+// in the reverse order.
+func (g *Generator) DropSQL() []string {
+
+	var sqlNames []string
+	for _, name := range g.Meta.Names() {
+		sqlNames = append(sqlNames, g.Meta.ForName(name).SQLName())
+	}
+
+	done := make(map[string]bool)
+	for _, name := range g.Meta.Names() {
+		ti := g.Meta.ForName(name)
+		for _, rn := range relationNames(ti) {
+			var joinName string
+			switch r := ti.RelationNamed(rn).(type) {
+			case *tmeta.BelongsToMany:
+				joinName = r.JoinName
+			case *tmeta.BelongsToManyIDs:
+				joinName = r.JoinName
+			default:
+				continue
+			}
+			if done[joinName] || g.Meta.ForName(joinName) != nil {
+				continue
+			}
+			done[joinName] = true
+			sqlNames = append(sqlNames, g.Meta.ReplacedSQLName(joinName))
+		}
+	}
+
+	ret := make([]string, 0, len(sqlNames))
+	for i := len(sqlNames) - 1; i >= 0; i-- {
+		ret = append(ret, "DROP TABLE "+g.quoteIdent(sqlNames[i]))
+	}
+	return ret
+}
+
+// CreateTableSQL returns the CREATE TABLE statement for the table provided, followed by
+// a CREATE INDEX statement for each index.
+func (g *Generator) CreateTableSQL(ti *tmeta.TableInfo) ([]string, error) {
+
+	d, err := g.dialect()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CREATE TABLE %s (\n", g.quoteIdent(ti.SQLName()))
+
+	inlinePK := false // primary key is part of the field type (e.g. SQLite3 auto increment)
+
+	var indexes []*index
+	indexMap := make(map[string]*index)
+
+	for _, fi := range ti.Fields() {
+
+		f, tagv := fi.SQLName, fi.Options
+		autoIncr := fi.IsPK && ti.PKAutoIncr()
+
+		sqlType := tagv.Get("sql_type")
+		if sqlType == "" {
+			sqlType, err = d.SQLType(fi.GoType, autoIncr)
+			if err != nil {
+				return nil, fmt.Errorf("table %q field %q: %v", ti.Name(), f, err)
+			}
+		}
+
+		fmt.Fprintf(&buf, "\t%s %s", g.quoteIdent(f), sqlType)
+		if fi.IsPK && strings.Contains(strings.ToUpper(sqlType), "PRIMARY KEY") {
+			if len(ti.SQLPKFields()) != 1 {
+				return nil, fmt.Errorf("table %q field %q: type %q declares the primary key inline, which requires exactly one primary key field, found %d",
+					ti.Name(), f, sqlType, len(ti.SQLPKFields()))
+			}
+			inlinePK = true
+		} else if fi.IsPK || fi.IsVersion || len(tagv["not_null"]) > 0 {
+			buf.WriteString(" NOT NULL")
+		}
+		if len(tagv["default"]) > 0 {
+			fmt.Fprintf(&buf, " DEFAULT %s", tagv.Get("default"))
+		}
+		buf.WriteString(",\n")
+
+		for _, opt := range []string{"index", "unique"} {
+			if len(tagv[opt]) == 0 {
+				continue
+			}
+			n := tagv.Get(opt)
+			if n == "" {
+				n = f
+			}
+			idx := indexMap[opt+":"+n]
+			if idx == nil {
+				suffix := "_idx"
+				if opt == "unique" {
+					suffix = "_key"
+				}
+				idx = &index{sqlName: ti.SQLName() + "_" + n + suffix, unique: opt == "unique"}
+				indexMap[opt+":"+n] = idx
+				indexes = append(indexes, idx)
+			}
+			idx.sqlFields = append(idx.sqlFields, f)
+		}
+	}
+
+	if !inlinePK {
+		fmt.Fprintf(&buf, "\tPRIMARY KEY (%s)\n", g.quoteIdentList(ti.SQLPKFields()))
+	} else {
+		buf.Truncate(buf.Len() - 2)
+		buf.WriteString("\n")
+	}
+	buf.WriteString(")")
+
+	ret := []string{buf.String()}
+	for _, idx := range indexes {
+		ret = append(ret, g.indexSQL(ti.SQLName(), idx))
+	}
+
+	return ret, nil
+}
+
+// joinTableSQL returns the statements to create the join table for a relation, if it is
+// a relation that uses a join table and that join table is not registered.  Join tables already
+// emitted are recorded in done to avoid duplicates.
+func (g *Generator) joinTableSQL(ti *tmeta.TableInfo, rel tmeta.Relation, done map[string]bool) ([]string, error) {
+
+	var joinName, sqlIDField, sqlOtherIDField string
+	var otherIDType reflect.Type
+
+	sf, ok := ti.GoType().FieldByName(rel.RelationGoValueField())
+	if !ok {
+		return nil, fmt.Errorf("relation %q refers to Go field %q which does not exist on %v", rel.RelationName(), rel.RelationGoValueField(), ti.GoType())
+	}
+
+	switch r := rel.(type) {
+	case *tmeta.BelongsToMany:
+		joinName, sqlIDField, sqlOtherIDField = r.JoinName, r.SQLIDField, r.SQLOtherIDField
+		targetTI := g.Meta.ForType(elemDerefType(sf.Type))
+		if targetTI == nil {
+			return nil, fmt.Errorf("%v is not registered", sf.Type)
+		}
+		otherIDType = sqlFieldType(targetTI, targetTI.SQLPKFields()[0])
+	case *tmeta.BelongsToManyIDs:
+		joinName, sqlIDField, sqlOtherIDField = r.JoinName, r.SQLIDField, r.SQLOtherIDField
+		otherIDType = derefType(sf.Type).Elem()
+	default:
+		return nil, nil
+	}
+
+	if done[joinName] || g.Meta.ForName(joinName) != nil {
+		return nil, nil
+	}
+	done[joinName] = true
+
+	// named the same way as it would be if it were registered
+	sqlJoinName := g.Meta.ReplacedSQLName(joinName)
+
+	d, err := g.dialect()
+	if err != nil {
+		return nil, err
+	}
+	idType, err := d.SQLType(sqlFieldType(ti, ti.SQLPKFields()[0]), false)
+	if err != nil {
+		return nil, fmt.Errorf("join table %q field %q: %v", joinName, sqlIDField, err)
+	}
+	otherType, err := d.SQLType(otherIDType, false)
+	if err != nil {
+		return nil, fmt.Errorf("join table %q field %q: %v", joinName, sqlOtherIDField, err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CREATE TABLE %s (\n", g.quoteIdent(sqlJoinName))
+	fmt.Fprintf(&buf, "\t%s %s NOT NULL,\n", g.quoteIdent(sqlIDField), idType)
+	fmt.Fprintf(&buf, "\t%s %s NOT NULL,\n", g.quoteIdent(sqlOtherIDField), otherType)
+	fmt.Fprintf(&buf, "\tPRIMARY KEY (%s)\n", g.quoteIdentList([]string{sqlIDField, sqlOtherIDField}))
+	buf.WriteString(")")
+
+	// the primary key covers lookups by sqlIDField, index the other side too
+	return []string{
+		buf.String(),
+		g.indexSQL(sqlJoinName, &index{sqlName: sqlJoinName + "_" + sqlOtherIDField + "_idx", sqlFields: []string{sqlOtherIDField}}),
+	}, nil
+}
+
+type index struct {
+	sqlName   string
+	unique    bool
+	sqlFields []string
+}
+
+func (g *Generator) indexSQL(sqlTable string, idx *index) string {
+	u := ""
+	if idx.unique {
+		u = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", u,
+		g.quoteIdent(idx.sqlName), g.quoteIdent(sqlTable), g.quoteIdentList(idx.sqlFields))
+}
+
+// dialect returns the Dialect to use, see Generator
+func (g *Generator) dialect() (tmeta.Dialect, error) {
+	if g.Dialect != nil {
+		return g.Dialect, nil
+	}
+	if d := g.Meta.Dialect(); d != nil {
+		return d, nil
+	}
+	return nil, errors.New("tmetaddl: no dialect, set Generator.Dialect or use Meta.SetDialect")
+}
+
+func (g *Generator) quoteIdent(s string) string {
+	if d, err := g.dialect(); err == nil {
+		return d.QuoteIdent(s)
+	}
+	return `"` + s + `"`
+}
+
+func (g *Generator) quoteIdentList(sl []string) string {
+	ret := make([]string, 0, len(sl))
+	for _, s := range sl {
+		ret = append(ret, g.quoteIdent(s))
+	}
+	return strings.Join(ret, ", ")
+}
+
+// relationNames returns the names of the relations on a table, sorted.
+func relationNames(ti *tmeta.TableInfo) []string {
+	ret := make([]string, 0, len(ti.RelationMap))
+	for n := range ti.RelationMap {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,157 @@
+package tmetaddl
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSQL(t *testing.T) {
+
+	assert := assert.New(t)
+
+	meta := newTestMeta()
+
+	stmts, err := New(meta, tmeta.SQLite3Dialect{}).CreateSQL()
+	assert.NoError(err)
+	for _, stmt := range stmts {
+		t.Logf("%s;", stmt)
+	}
+
+	all := strings.Join(stmts, ";\n")
+	assert.Contains(all, `CREATE TABLE "test_author" (
+	"author_id" TEXT NOT NULL,
+	"nom_de_plume" TEXT NOT NULL,
+	PRIMARY KEY ("author_id")
+)`)
+	assert.Contains(all, `CREATE UNIQUE INDEX "test_author_nom_de_plume_key" ON "test_author" ("nom_de_plume")`)
+	assert.Contains(all, `CREATE INDEX "test_publisher_company_idx" ON "test_publisher" ("company_name", "country")`)
+	assert.Contains(all, `"country" TEXT DEFAULT 'US'`)
+	assert.Contains(all, `"version" INTEGER NOT NULL`)
+	assert.Contains(all, `"price" DECIMAL(10,2)`)
+	assert.Contains(all, `"create_time" TEXT`)
+	assert.Contains(all, `"category_info_id" INTEGER PRIMARY KEY AUTOINCREMENT`)
+	assert.Contains(all, "\"info_stuff\" TEXT\n")
+
+	// join table is an ordinary registered table
+	assert.Equal(1, strings.Count(all, `CREATE TABLE "test_book_category"`))
+	assert.Contains(all, `PRIMARY KEY ("book_id", "category_id")`)
+
+	// make sure SQLite3 actually accepts it
+	conn, err := dbr.Open("sqlite3", fmt.Sprintf(`file:tmetaddl_test%d?mode=memory&cache=shared`, rand.Int31()), nil)
+	assert.NoError(err)
+	defer conn.Close()
+	for _, stmt := range stmts {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+
+	// and that the tables work as expected
+	sess := conn.NewSession(nil)
+	bookT := meta.For(Book{})
+	_, err = sess.InsertInto(bookT.SQLName()).
+		Columns(bookT.SQLFields(true)...).
+		Record(&Book{BookID: "book_0001", Title: "Ender's Game", Price: "7.99"}).
+		Exec()
+	assert.NoError(err)
+	var book Book
+	assert.NoError(sess.Select(bookT.SQLFields(true)...).From(bookT.SQLName()).LoadOne(&book))
+	assert.Equal("Ender's Game", book.Title)
+
+	// and can be dropped again
+	drops := New(meta, tmeta.SQLite3Dialect{}).DropSQL()
+	assert.Equal(`DROP TABLE "test_publisher"`, drops[0])
+	assert.Len(drops, len(meta.Names()))
+	for _, stmt := range drops {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+
+	// unregistered join tables are generated from the relations
+	meta2 := tmeta.NewMeta()
+	meta2.MustParse(&Book{})
+	meta2.MustParse(&Category{})
+	meta2.ReplaceSQLNames(func(name string) string { return "test2_" + name })
+	g2 := New(meta2, tmeta.SQLite3Dialect{})
+	stmts, err = g2.CreateSQL()
+	assert.NoError(err)
+	all = strings.Join(stmts, ";\n")
+	assert.Equal(1, strings.Count(all, `CREATE TABLE "test2_book_category"`))
+	assert.Contains(all, `CREATE TABLE "test2_book_category" (
+	"book_id" TEXT NOT NULL,
+	"category_id" TEXT NOT NULL,
+	PRIMARY KEY ("book_id", "category_id")
+)`)
+	assert.Contains(all, `CREATE INDEX "test2_book_category_category_id_idx" ON "test2_book_category" ("category_id")`)
+	for _, stmt := range stmts {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+	drops = g2.DropSQL()
+	assert.Equal(`DROP TABLE "test2_book_category"`, drops[0])
+	assert.Len(drops, len(meta2.Names())+1)
+	for _, stmt := range drops {
+		_, err := conn.Exec(stmt)
+		assert.NoError(err, stmt)
+	}
+
+}
+
+type testDialect struct {
+	tmeta.PostgresDialect
+}
+
+func (testDialect) Name() string { return "testdb" }
+
+func (d testDialect) SQLType(t reflect.Type, autoIncr bool) (string, error) {
+	if t.Kind() == reflect.String {
+		return "STRING", nil
+	}
+	return d.PostgresDialect.SQLType(t, autoIncr)
+}
+
+func TestCreateSQLDialects(t *testing.T) {
+
+	assert := assert.New(t)
+
+	meta := newTestMeta()
+
+	stmts, err := New(meta, tmeta.MySQLDialect{}).CreateSQL()
+	assert.NoError(err)
+	all := strings.Join(stmts, ";\n")
+	assert.Contains(all, "CREATE TABLE `test_author`")
+	assert.Contains(all, "`author_id` VARCHAR(255) NOT NULL")
+	assert.Contains(all, "`category_info_id` BIGINT AUTO_INCREMENT NOT NULL")
+	assert.Contains(all, "PRIMARY KEY (`category_info_id`)")
+	assert.Contains(all, "`in_print` BOOLEAN")
+	assert.Contains(all, "`create_time` DATETIME(6)")
+
+	stmts, err = New(meta, tmeta.PostgresDialect{}).CreateSQL()
+	assert.NoError(err)
+	all = strings.Join(stmts, ";\n")
+	assert.Contains(all, `"category_info_id" BIGSERIAL NOT NULL`)
+	assert.Contains(all, `"create_time" TIMESTAMP`)
+	assert.Contains(all, `"in_print" BOOLEAN`)
+
+	// a dialect that isn't one of the included ones
+	stmts, err = New(meta, testDialect{}).CreateSQL()
+	assert.NoError(err)
+	all = strings.Join(stmts, ";\n")
+	assert.Contains(all, `"author_id" STRING NOT NULL`)
+	assert.Contains(all, `"category_info_id" BIGSERIAL NOT NULL`)
+
+	// the Meta's dialect is used if none is given
+	_, err = New(meta, nil).CreateSQL()
+	assert.Error(err)
+	meta.SetDialect(testDialect{})
+	stmts2, err := New(meta, nil).CreateSQL()
+	assert.NoError(err)
+	assert.Equal(stmts, stmts2)
+
+}
@@ -0,0 +1,76 @@
+package tmetaddl
+
+import (
+	"github.com/gocaveman/tmeta"
+	"github.com/gocaveman/tmeta/tmetautil"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Author struct {
+	AuthorID   string `db:"author_id" tmeta:"pk"`
+	NomDePlume string `db:"nom_de_plume" tmeta:"not_null,unique"`
+
+	BookList []Book `db:"-" tmeta:"has_many"`
+}
+
+type Publisher struct {
+	PublisherID string `db:"publisher_id" tmeta:"pk"`
+	CompanyName string `db:"company_name" tmeta:"index=company"`
+	Country     string `db:"country" tmeta:"index=company,default='US'"`
+	Version     int64  `db:"version" tmeta:"version"`
+
+	BookList []Book `db:"-" tmeta:"has_many,relation_name=book_list"`
+}
+
+type Book struct {
+	BookID string `db:"book_id" tmeta:"pk"`
+
+	AuthorID string  `db:"author_id" tmeta:"index"`
+	Author   *Author `db:"-" tmeta:"belongs_to,sql_id_field=author_id"`
+
+	PublisherID string     `db:"publisher_id" tmeta:"index"`
+	Publisher   *Publisher `db:"-" tmeta:"belongs_to"`
+
+	Title      string           `db:"title"`
+	Price      string           `db:"price" tmeta:"sql_type=DECIMAL(10,2)"`
+	InPrint    bool             `db:"in_print"`
+	CreateTime tmetautil.DBTime `db:"create_time"`
+
+	CategoryList []Category `db:"-" tmeta:"belongs_to_many,join_name=book_category"`
+
+	CategoryIDList []string `db:"-" tmeta:"belongs_to_many_ids,join_name=book_category"`
+}
+
+type Category struct {
+	CategoryID   string        `db:"category_id" tmeta:"pk"`
+	Name         string        `db:"name"`
+	BookList     []Book        `db:"-" tmeta:"belongs_to_many,join_name=book_category"`
+	CategoryInfo *CategoryInfo `db:"-" tmeta:"has_one"`
+}
+
+type CategoryInfo struct {
+	CategoryInfoID int64     `db:"category_info_id" tmeta:"pk,auto_incr"`
+	CategoryID     string    `db:"category_id"`
+	InfoStuff      *string   `db:"info_stuff"`
+	Category       *Category `db:"-" tmeta:"belongs_to"`
+}
+
+// BookCategory is the join table for the book and category relations
+type BookCategory struct {
+	BookID     string `db:"book_id" tmeta:"pk"`
+	CategoryID string `db:"category_id" tmeta:"pk,index"`
+}
+
+func newTestMeta() *tmeta.Meta {
+	meta := tmeta.NewMeta()
+	meta.SetStrictTags(true) // the tmetaddl options are registered
+	meta.MustParse(&Author{})
+	meta.MustParse(&Publisher{})
+	meta.MustParse(&Book{})
+	meta.MustParse(&Category{})
+	meta.MustParse(&CategoryInfo{})
+	meta.MustParse(&BookCategory{})
+	meta.ReplaceSQLNames(func(name string) string { return "test_" + name })
+	return meta
+}
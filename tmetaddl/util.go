@@ -0,0 +1,29 @@
+package tmetaddl
+
+import (
+	"reflect"
+
+	"github.com/gocaveman/tmeta"
+)
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// like derefType but will take a slice look at it's element type,
+// non-slice types are treated the same as by derefType
+func elemDerefType(t reflect.Type) reflect.Type {
+	t = derefType(t)
+	if t.Kind() == reflect.Slice {
+		t = derefType(t.Elem())
+	}
+	return t
+}
+
+// sqlFieldType returns the Go type of the struct field for the SQL field name given.
+func sqlFieldType(ti *tmeta.TableInfo, sqlFieldName string) reflect.Type {
+	return ti.FieldBySQLName(sqlFieldName).GoType
+}
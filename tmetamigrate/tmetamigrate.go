@@ -0,0 +1,414 @@
+// Run versioned schema migrations, using table names from tmeta.
+//
+// Each Migration has a Version and is applied at most once, the versions which have been
+// applied are recorded in a tracking table ("schema_migrations" by default, with any
+// Meta.ReplaceSQLNames replacements applied so it gets the same prefix).  Migrations
+// are run in ascending order of Version (compared as strings, so use zero padded numbers
+// or timestamps, e.g. "0001" or "20190801120000"), each inside it's own transaction.
+// Note that MySQL implicitly commits most DDL statements, so a failed migration
+// there may leave partial changes behind.
+//
+// SQL statements can refer to registered tables by their logical name as {{name}},
+// which is replaced by the table's SQLName.  This way migrations work the same regardless
+// of any prefix applied with Meta.ReplaceSQLNames.
+//
+// The first migration is usually the SQL to create the tables, from MetaMigration.  Since that
+// is generated from your structs as they are now, it should be written out once with WriteGo
+// and the file committed, so the SQL doesn't change as the structs do and later migrations
+// apply cleanly to a new database.  Example:
+//
+//	r := tmetamigrate.New(meta, tmeta.PostgresDialect{})
+//
+//	// run once (e.g. with go generate) and commit migrations/m0001.go
+//	err := r.MustMetaMigration("0001").WriteGo(f, "migrations", "M0001")
+//
+//	// at startup
+//	r.Add(migrations.M0001, &tmetamigrate.Migration{
+//		Version: "0002",
+//		UpSQL: tmetamigrate.DialectSQL{
+//			"":        {`ALTER TABLE {{widget}} ADD COLUMN color VARCHAR(64)`},
+//			"sqlite3": {`ALTER TABLE {{widget}} ADD COLUMN color TEXT`},
+//		},
+//		DownSQL: tmetamigrate.DialectSQL{
+//			"": {`ALTER TABLE {{widget}} DROP COLUMN color`},
+//		},
+//	})
+//	err = r.Up(ctx, conn)
+package tmetamigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocaveman/tmeta/tmetaddl"
+	"github.com/gocraft/dbr"
+)
+
+// DefaultSQLTableName is the name of the table used to track which migrations have been applied,
+// before any Meta.ReplaceSQLNames replacements.
+const DefaultSQLTableName = "schema_migrations"
+
+// DialectSQL is a list of SQL statements for each dialect, keyed by the dialect's Name.
+// The statements under the empty name ("") are used for any dialect not listed.
+type DialectSQL map[string][]string
+
+// For returns the statements for the dialect provided, falling back to the empty name.
+func (ds DialectSQL) For(dialect tmeta.Dialect) []string {
+	if ret, ok := ds[dialect.Name()]; ok {
+		return ret
+	}
+	return ds[""]
+}
+
+// Migration is a single versioned change to the database.  When applying it the SQL
+// statements are run first and then the function (either may be omitted), and the
+// same for reverting it.  A migration without DownSQL or DownFunc cannot be reverted.
+type Migration struct {
+	Version  string
+	UpSQL    DialectSQL
+	DownSQL  DialectSQL
+	UpFunc   func(ctx context.Context, tx *dbr.Tx) error
+	DownFunc func(ctx context.Context, tx *dbr.Tx) error
+}
+
+// New returns a new Runner.  If dialect is nil the Meta's Dialect is used.
+func New(meta *tmeta.Meta, dialect tmeta.Dialect) *Runner {
+	return &Runner{
+		Meta:    meta,
+		Dialect: dialect,
+	}
+}
+
+// Runner applies and reverts migrations.
+type Runner struct {
+	*tmeta.Meta
+	Dialect      tmeta.Dialect // if nil the Meta's Dialect is used
+	SQLTableName string        // name of the tracking table, if empty it is Meta.ReplacedSQLName(DefaultSQLTableName)
+
+	migrations []*Migration
+}
+
+// Add adds one or more migrations to the Runner.  They may be added in any order.
+func (r *Runner) Add(migrations ...*Migration) *Runner {
+	r.migrations = append(r.migrations, migrations...)
+	return r
+}
+
+// MustMetaMigration is the same as MetaMigration but panics on error.
+func (r *Runner) MustMetaMigration(version string) *Migration {
+	ret, err := r.MetaMigration(version)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// MetaMigration returns a migration that creates the tables for everything in the Meta
+// (using tmetaddl) and drops them when reverted.  It is intended to be the first migration.
+// Tables are referred to as {{name}} so the SQL does not depend on Meta.ReplaceSQLNames.
+// Keep in mind the SQL is generated from the Meta as it is at the time this is called,
+// so use WriteGo to save it as a file you commit instead of calling this at runtime.
+// Otherwise a new database gets the tables as your structs are now and later
+// migrations which change them will fail.
+func (r *Runner) MetaMigration(version string) (*Migration, error) {
+	d, err := r.dialect()
+	if err != nil {
+		return nil, err
+	}
+	g := tmetaddl.New(placeholderMeta(r.Meta), d)
+	stmts, err := g.CreateSQL()
+	if err != nil {
+		return nil, err
+	}
+	return &Migration{
+		Version: version,
+		UpSQL:   DialectSQL{d.Name(): stmts},
+		DownSQL: DialectSQL{d.Name(): g.DropSQL()},
+	}, nil
+}
+
+// Applied returns the versions which have been applied to the database, sorted.
+// The tracking table is created if it does not exist.
+func (r *Runner) Applied(ctx context.Context, conn *dbr.Connection) ([]string, error) {
+
+	sess := conn.NewSession(nil)
+
+	_, err := sess.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+r.sqlTableName()+
+		` (version VARCHAR(255) NOT NULL, PRIMARY KEY (version))`)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	_, err = sess.Select("version").From(r.sqlTableName()).OrderBy("version").LoadContext(ctx, &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Up applies each migration which has not already been applied, in order.
+// If a migration fails, the ones before it remain applied.
+func (r *Runner) Up(ctx context.Context, conn *dbr.Connection) error {
+
+	migrations, err := r.sortedMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied, err := r.Applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	appliedMap := make(map[string]bool, len(applied))
+	for _, v := range applied {
+		appliedMap[v] = true
+	}
+
+	for _, m := range migrations {
+		if appliedMap[m.Version] {
+			continue
+		}
+		err := r.run(ctx, conn, m, true)
+		if err != nil {
+			return fmt.Errorf("tmetamigrate: applying migration %q: %v", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// Down reverts the most recently applied migration.  It is a no-op if none have been applied.
+func (r *Runner) Down(ctx context.Context, conn *dbr.Connection) error {
+
+	applied, err := r.Applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+
+	if len(applied) == 1 {
+		return r.DownTo(ctx, conn, "")
+	}
+	return r.DownTo(ctx, conn, applied[len(applied)-2])
+}
+
+// DownTo reverts each applied migration with a version greater than the one provided,
+// newest first.  An empty version reverts all of them.
+func (r *Runner) DownTo(ctx context.Context, conn *dbr.Connection, version string) error {
+
+	migrations, err := r.sortedMigrations()
+	if err != nil {
+		return err
+	}
+	migrationMap := make(map[string]*Migration, len(migrations))
+	for _, m := range migrations {
+		migrationMap[m.Version] = m
+	}
+
+	applied, err := r.Applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		v := applied[i]
+		if v <= version {
+			break
+		}
+		m := migrationMap[v]
+		if m == nil {
+			return fmt.Errorf("tmetamigrate: applied migration %q is unknown, cannot revert it", v)
+		}
+		err := r.run(ctx, conn, m, false)
+		if err != nil {
+			return fmt.Errorf("tmetamigrate: reverting migration %q: %v", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// run applies or reverts a migration inside a transaction and updates the tracking table
+func (r *Runner) run(ctx context.Context, conn *dbr.Connection, m *Migration, up bool) error {
+
+	d, err := r.dialect()
+	if err != nil {
+		return err
+	}
+
+	stmts, f, what := m.UpSQL.For(d), m.UpFunc, "up"
+	if !up {
+		stmts, f, what = m.DownSQL.For(d), m.DownFunc, "down"
+	}
+	if len(stmts) == 0 && f == nil {
+		return fmt.Errorf("no %s SQL or function for dialect %q", what, d.Name())
+	}
+
+	tx, err := conn.NewSession(nil).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	for _, stmt := range stmts {
+		stmt, err := r.ExpandSQL(stmt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("%v (SQL: %s)", err, stmt)
+		}
+	}
+
+	if f != nil {
+		err := f(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.InsertInto(r.sqlTableName()).Pair("version", m.Version).ExecContext(ctx)
+	} else {
+		_, err = tx.DeleteFrom(r.sqlTableName()).Where("version = ?", m.Version).ExecContext(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqlTableName returns the name of the tracking table, see Runner
+func (r *Runner) sqlTableName() string {
+	if r.SQLTableName != "" {
+		return r.SQLTableName
+	}
+	return r.Meta.ReplacedSQLName(DefaultSQLTableName)
+}
+
+// dialect returns the Dialect to use, see Runner
+func (r *Runner) dialect() (tmeta.Dialect, error) {
+	if r.Dialect != nil {
+		return r.Dialect, nil
+	}
+	if d := r.Meta.Dialect(); d != nil {
+		return d, nil
+	}
+	return nil, errors.New("tmetamigrate: no dialect, set Runner.Dialect or use Meta.SetDialect")
+}
+
+var placeholderRE = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// ExpandSQL replaces each {{name}} in the SQL provided with the SQLName of the table with that name.
+// An error is returned if a name is not registered.
+func (r *Runner) ExpandSQL(stmt string) (string, error) {
+	var reterr error
+	ret := placeholderRE.ReplaceAllStringFunc(stmt, func(s string) string {
+		name := placeholderRE.FindStringSubmatch(s)[1]
+		ti := r.Meta.ForName(name)
+		if ti == nil {
+			reterr = fmt.Errorf("table %q is not registered", name)
+			return s
+		}
+		return ti.SQLName()
+	})
+	return ret, reterr
+}
+
+// sortedMigrations returns the migrations sorted by version and checks for duplicates
+func (r *Runner) sortedMigrations() ([]*Migration, error) {
+	ret := append([]*Migration(nil), r.migrations...)
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	for i, m := range ret {
+		if m.Version == "" {
+			return nil, errors.New("tmetamigrate: migration with empty version")
+		}
+		if i > 0 && ret[i-1].Version == m.Version {
+			return nil, fmt.Errorf("tmetamigrate: duplicate migration version %q", m.Version)
+		}
+	}
+	return ret, nil
+}
+
+// placeholderMeta returns a copy of meta with the SQLName of each table set to its {{name}}
+// placeholder.  Tables with a name that can't be a placeholder, or an SQLName with a dot
+// (which would be quoted as one name), keep their SQLName.
+func placeholderMeta(meta *tmeta.Meta) *tmeta.Meta {
+	ret := tmeta.NewMeta()
+	for _, name := range meta.Names() {
+		ti := *meta.ForName(name)
+		if placeholderRE.MatchString("{{"+name+"}}") && !strings.Contains(ti.SQLName(), ".") {
+			ti.SetSQLName("{{" + name + "}}")
+		}
+		ret.SetTableInfo(ti.GoType(), &ti)
+	}
+	return ret
+}
+
+// WriteGo writes a Go source file in package pkg declaring a variable named varName which is
+// this migration.  This is how the SQL from MetaMigration should be saved and committed, see
+// the package documentation.  A migration with an UpFunc or DownFunc cannot be written.
+func (m *Migration) WriteGo(w io.Writer, pkg, varName string) error {
+
+	if m.UpFunc != nil || m.DownFunc != nil {
+		return fmt.Errorf("tmetamigrate: migration %q has an UpFunc or DownFunc and cannot be written as Go source", m.Version)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	buf.WriteString("import \"github.com/gocaveman/tmeta/tmetamigrate\"\n\n")
+	fmt.Fprintf(&buf, "// %s was generated by tmetamigrate.\n", varName)
+	fmt.Fprintf(&buf, "var %s = &tmetamigrate.Migration{\n", varName)
+	fmt.Fprintf(&buf, "Version: %q,\n", m.Version)
+	writeDialectSQL(&buf, "UpSQL", m.UpSQL)
+	writeDialectSQL(&buf, "DownSQL", m.DownSQL)
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func writeDialectSQL(buf *bytes.Buffer, field string, ds DialectSQL) {
+	if len(ds) == 0 {
+		return
+	}
+	names := make([]string, 0, len(ds))
+	for n := range ds {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(buf, "%s: tmetamigrate.DialectSQL{\n", field)
+	for _, n := range names {
+		fmt.Fprintf(buf, "%q: {\n", n)
+		for _, stmt := range ds[n] {
+			// raw strings keep the SQL readable, unless it has a backtick (MySQL quoting)
+			if strings.ContainsAny(stmt, "`\r") {
+				buf.WriteString(strconv.Quote(stmt))
+			} else {
+				buf.WriteString("`" + stmt + "`")
+			}
+			buf.WriteString(",\n")
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("},\n")
+}
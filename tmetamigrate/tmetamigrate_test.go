@@ -0,0 +1,145 @@
+package tmetamigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"math/rand"
+	"testing"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/gocraft/dbr"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Widget struct {
+	WidgetID string `db:"widget_id" tmeta:"pk"`
+	Name     string `db:"name"`
+}
+
+func TestMigrations(t *testing.T) {
+
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	meta := tmeta.NewMeta()
+	meta.MustParse(&Widget{})
+	meta.ReplaceSQLNames(func(name string) string { return "test_" + name })
+
+	conn, err := dbr.Open("sqlite3", fmt.Sprintf(`file:tmetamigrate_test%d?mode=memory&cache=shared`, rand.Int31()), nil)
+	assert.NoError(err)
+	defer conn.Close()
+	sess := conn.NewSession(nil)
+
+	r := New(meta, tmeta.SQLite3Dialect{})
+	r.Add(&Migration{
+		Version: "0003",
+		UpFunc: func(ctx context.Context, tx *dbr.Tx) error {
+			_, err := tx.InsertInto("test_widget").Pair("widget_id", "widget_0001").Pair("name", "Sprocket").ExecContext(ctx)
+			return err
+		},
+		DownFunc: func(ctx context.Context, tx *dbr.Tx) error {
+			_, err := tx.DeleteFrom("test_widget").ExecContext(ctx)
+			return err
+		},
+	}, &Migration{
+		Version: "0002",
+		UpSQL: DialectSQL{
+			"":        {`CREATE INDEX test_widget_name_idx ON {{widget}} (name(32))`},
+			"sqlite3": {`CREATE INDEX test_widget_name_idx ON {{widget}} (name)`},
+		},
+		DownSQL: DialectSQL{
+			"": {`DROP INDEX test_widget_name_idx`},
+		},
+	}, r.MustMetaMigration("0001"))
+
+	assert.NoError(r.Up(ctx, conn))
+	applied, err := r.Applied(ctx, conn)
+	assert.NoError(err)
+	assert.Equal([]string{"0001", "0002", "0003"}, applied)
+
+	var names []string
+	_, err = sess.Select("name").From("test_widget").Load(&names)
+	assert.NoError(err)
+	assert.Equal([]string{"Sprocket"}, names)
+
+	// running again is a no-op
+	assert.NoError(r.Up(ctx, conn))
+
+	// the tracking table gets the same prefix as the others
+	var versions []string
+	_, err = sess.Select("version").From("test_schema_migrations").Load(&versions)
+	assert.NoError(err)
+	assert.Equal(applied, versions)
+
+	// a failing migration is rolled back and not recorded
+	r.Add(&Migration{
+		Version: "0004",
+		UpSQL:   DialectSQL{"": {`CREATE TABLE {{widget}}_extra (x INTEGER)`}},
+		UpFunc: func(ctx context.Context, tx *dbr.Tx) error {
+			return errors.New("nope")
+		},
+	})
+	err = r.Up(ctx, conn)
+	assert.Error(err)
+	assert.Contains(err.Error(), `"0004"`)
+	applied, err = r.Applied(ctx, conn)
+	assert.NoError(err)
+	assert.Equal([]string{"0001", "0002", "0003"}, applied)
+	_, err = sess.Select("x").From("test_widget_extra").Load(&names)
+	assert.Error(err)
+
+	// revert one
+	assert.NoError(r.Down(ctx, conn))
+	names = nil
+	_, err = sess.Select("name").From("test_widget").Load(&names)
+	assert.NoError(err)
+	assert.Empty(names)
+
+	// revert the rest
+	assert.NoError(r.DownTo(ctx, conn, ""))
+	applied, err = r.Applied(ctx, conn)
+	assert.NoError(err)
+	assert.Empty(applied)
+	_, err = sess.Select("name").From("test_widget").Load(&names)
+	assert.Error(err)
+
+	// unknown table names and duplicate versions are errors
+	_, err = r.ExpandSQL(`SELECT * FROM {{gadget}}`)
+	assert.Error(err)
+	r.Add(&Migration{Version: "0001"})
+	assert.Error(r.Up(ctx, conn))
+
+}
+
+func TestWriteGo(t *testing.T) {
+
+	assert := assert.New(t)
+
+	meta := tmeta.NewMeta()
+	meta.MustParse(&Widget{})
+	meta.ReplaceSQLNames(func(name string) string { return "test_" + name })
+
+	m := New(meta, tmeta.MySQLDialect{}).MustMetaMigration("0001")
+	var buf bytes.Buffer
+	assert.NoError(m.WriteGo(&buf, "migrations", "M0001"))
+	src := buf.String()
+	t.Logf("%s", src)
+
+	// SQL refers to the tables by name, not the current SQLName
+	assert.Contains(src, "package migrations\n")
+	assert.Contains(src, "var M0001 = &tmetamigrate.Migration{")
+	assert.Contains(src, `"CREATE TABLE `+"`{{widget}}`"+` (\n`)
+	assert.NotContains(src, "test_widget")
+	_, err := parser.ParseFile(token.NewFileSet(), "m0001.go", src, 0)
+	assert.NoError(err)
+
+	m.UpFunc = func(ctx context.Context, tx *dbr.Tx) error { return nil }
+	assert.Error(m.WriteGo(&buf, "migrations", "M0001"))
+
+}
@@ -3,7 +3,10 @@ package tmetautil
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
+
+	"github.com/gocaveman/tmeta"
 )
 
 // Op is one of the supported SQL where operators used with Criteria and Criterion.
@@ -18,8 +21,29 @@ const (
 	GteOp  Op = ">="
 	LikeOp Op = "like"
 	InOp   Op = "in"
+
+	IsNullOp     Op = "isnull"     // field IS NULL, value is ignored
+	NotNullOp    Op = "notnull"    // field IS NOT NULL, value is ignored
+	BetweenOp    Op = "between"    // value is a slice of two values, the lower and upper bound (inclusive)
+	NotInOp      Op = "notin"      // value is a slice
+	ILikeOp      Op = "ilike"      // case insensitive like, the escape character is '!'
+	StartsWithOp Op = "startswith" // value is the prefix, wildcard characters in it are escaped
+	ContainsOp   Op = "contains"   // value is the substring, wildcard characters in it are escaped
+	FullTextOp   Op = "fulltext"   // value is the text to search for, requires a dialect (see SQLDialect)
 )
 
+// likeEscapeChar is the escape character used with StartsWithOp and ContainsOp (and
+// ILikeOp, see tmeta.Dialect.ILikeSQL), it is not backslash because that would need
+// different quoting in different databases
+const likeEscapeChar = "!"
+
+var likeEscaper = strings.NewReplacer(likeEscapeChar, likeEscapeChar+likeEscapeChar, "%", likeEscapeChar+"%", "_", likeEscapeChar+"_")
+
+// EscapeLike escapes the wildcard characters in s for use with LIKE and the escape character '!'.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 // Criterion is an individual expression that has a field, an op(erator) and a value.
 // It also supports Not for inverting the criterion, and Or can be used to provide
 // a list of other expressions to be ORed together.
@@ -39,6 +63,11 @@ func (c Criterion) CheckFieldNames(fields ...string) error {
 		return err
 	}
 
+	// only an Or, no field of it's own
+	if c.Field == "" && c.Op == Op("") && len(c.Or) > 0 {
+		return nil
+	}
+
 	for _, f := range fields {
 		if f == c.Field {
 			return nil
@@ -51,6 +80,8 @@ func (c Criterion) CheckFieldNames(fields ...string) error {
 // it's operator is any of the valid ones except LikeOp, which requires at least likePrefixLen
 // characters at the start without a wildcard character ('%'' or '_').  The idea is to
 // restrict queries to specific (usually indexed) fields to avoid excessive database load.
+// NotInOp, ILikeOp and ContainsOp are never a match since they can't use an index, and
+// StartsWithOp requires a value of at least likePrefixLen characters.
 func (c Criterion) ContainsMatch(likePrefixLen int, fields ...string) bool {
 
 	// for Or they must all have a match
@@ -74,6 +105,13 @@ func (c Criterion) ContainsMatch(likePrefixLen int, fields ...string) bool {
 	if !matchField(c.Field) {
 		return false
 	}
+	switch c.Op {
+	case NotInOp, ILikeOp, ContainsOp: // these can't use a (regular) index
+		return false
+	case StartsWithOp:
+		s, _ := c.Value.(string)
+		return len(s) >= likePrefixLen
+	}
 	if c.Op == LikeOp {
 		s, _ := c.Value.(string)
 		if len(s) < likePrefixLen {
@@ -95,22 +133,70 @@ func (c Criterion) ContainsMatch(likePrefixLen int, fields ...string) bool {
 
 // SQL converts to a SQL where clause and the corresponding arguments for it.
 func (ca Criterion) SQL() (stmt string, args []interface{}, err error) {
+	return ca.SQLQuoted(noQuote)
+}
+
+// SQLQuoted is like SQL but field names are passed through the quote function provided.
+func (ca Criterion) SQLQuoted(quote func(sqlName string) string) (stmt string, args []interface{}, err error) {
+	return ca.SQLDialect(nil, quote)
+}
+
+// SQLDialect is like SQLQuoted but uses the dialect provided for operators which differ between
+// databases (ILikeOp and FullTextOp).  If d is nil, ILikeOp uses LOWER() on both sides and
+// FullTextOp is an error.
+func (ca Criterion) SQLDialect(d tmeta.Dialect, quote func(sqlName string) string) (stmt string, args []interface{}, err error) {
 
 	var buf bytes.Buffer
 
-	if ca.Not {
-		buf.WriteString("NOT ")
-	}
+	field := quote(ca.Field)
 
 	noOp := false
 	switch ca.Op {
 	case EqOp, NeOp, LtOp, LteOp, GtOp, GteOp, LikeOp,
 		InOp: // IN operator happens to work the same due to dbr's handling of "x IN ?"
-		buf.WriteString(ca.Field)
+		buf.WriteString(field)
 		buf.WriteString(" ")
 		buf.WriteString(string(ca.Op))
 		buf.WriteString(" ?")
 		args = append(args, ca.Value)
+	case NotInOp:
+		buf.WriteString(field + " NOT IN ?")
+		args = append(args, ca.Value)
+	case IsNullOp:
+		buf.WriteString(field + " IS NULL")
+	case NotNullOp:
+		buf.WriteString(field + " IS NOT NULL")
+	case BetweenOp:
+		v := reflect.ValueOf(ca.Value)
+		if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Len() != 2 {
+			return "", nil, fmt.Errorf("operator %q on field %q requires a value with two elements, got %#v", ca.Op, ca.Field, ca.Value)
+		}
+		buf.WriteString(field + " BETWEEN ? AND ?")
+		args = append(args, v.Index(0).Interface(), v.Index(1).Interface())
+	case ILikeOp:
+		if d != nil {
+			buf.WriteString(d.ILikeSQL(field))
+		} else {
+			buf.WriteString("LOWER(" + field + ") LIKE LOWER(?) ESCAPE '" + likeEscapeChar + "'")
+		}
+		args = append(args, ca.Value)
+	case StartsWithOp, ContainsOp:
+		s, ok := ca.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("operator %q on field %q requires a string value, got %T", ca.Op, ca.Field, ca.Value)
+		}
+		s = EscapeLike(s) + "%"
+		if ca.Op == ContainsOp {
+			s = "%" + s
+		}
+		buf.WriteString(field + " LIKE ? ESCAPE '" + likeEscapeChar + "'")
+		args = append(args, s)
+	case FullTextOp:
+		if d == nil {
+			return "", nil, fmt.Errorf("operator %q on field %q requires a dialect", ca.Op, ca.Field)
+		}
+		buf.WriteString(d.FullTextSQL(field))
+		args = append(args, ca.Value)
 	case Op(""):
 		noOp = true
 	default:
@@ -120,7 +206,7 @@ func (ca Criterion) SQL() (stmt string, args []interface{}, err error) {
 	if len(ca.Or) > 0 {
 		var sl []string
 		for _, ci := range ca.Or {
-			s, a, err := ci.SQL()
+			s, a, err := ci.SQLDialect(d, quote)
 			if err != nil {
 				return "", nil, err
 			}
@@ -137,6 +223,14 @@ func (ca Criterion) SQL() (stmt string, args []interface{}, err error) {
 		return "", nil, nil
 	}
 
+	if ca.Not {
+		// the Or group is already in parens, an operator with an Or needs another pair so NOT applies to both
+		if !noOp && len(ca.Or) > 0 {
+			return "NOT (" + buf.String() + ")", args, nil
+		}
+		return "NOT " + buf.String(), args, nil
+	}
+
 	return buf.String(), args, nil
 
 }
@@ -171,10 +265,20 @@ func (ca Criteria) ContainsMatch(likePrefixLen int, fields ...string) bool {
 
 // SQL converts to a SQL where clause and the corresponding arguments for it.
 func (ca Criteria) SQL() (stmt string, args []interface{}, err error) {
+	return ca.SQLQuoted(noQuote)
+}
+
+// SQLQuoted is like SQL but field names are passed through the quote function provided.
+func (ca Criteria) SQLQuoted(quote func(sqlName string) string) (stmt string, args []interface{}, err error) {
+	return ca.SQLDialect(nil, quote)
+}
+
+// SQLDialect is like SQLQuoted but uses the dialect provided, see Criterion.SQLDialect.
+func (ca Criteria) SQLDialect(d tmeta.Dialect, quote func(sqlName string) string) (stmt string, args []interface{}, err error) {
 
 	var slist []string
 	for _, c := range ca {
-		s, a, err := c.SQL()
+		s, a, err := c.SQLDialect(d, quote)
 		if err != nil {
 			return "", nil, err
 		}
@@ -185,3 +289,5 @@ func (ca Criteria) SQL() (stmt string, args []interface{}, err error) {
 	return strings.Join(slist, " AND "), args, nil
 
 }
+
+func noQuote(sqlName string) string { return sqlName }
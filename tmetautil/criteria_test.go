@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/gocaveman/tmeta"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -109,4 +110,76 @@ func TestCriteria(t *testing.T) {
 	assert.Len(a, 0)
 	assert.Equal(``, s)
 
+	// quoted field names
+	ca = Criteria{
+		{Field: "f1", Op: EqOp, Value: "tacos"},
+		{Or: Criteria{{Field: "f2", Op: GtOp, Value: 7}, {Not: true, Field: "f3", Op: LikeOp, Value: "ab%"}}},
+	}
+	s, a, err = ca.SQLQuoted(func(n string) string { return `"` + n + `"` })
+	assert.NoError(err)
+	assert.Len(a, 3)
+	assert.Equal(`"f1" = ? AND ("f2" > ? OR NOT "f3" like ?)`, s)
+	assert.NoError(ca.CheckFieldNames("f1", "f2", "f3"))
+	assert.Error(ca.CheckFieldNames("f1", "f2"))
+
+	// not with both an operator and an or covers both
+	ca = Criteria{{Not: true, Field: "f1", Op: EqOp, Value: 1, Or: Criteria{{Field: "f2", Op: EqOp, Value: 2}, {Field: "f3", Op: EqOp, Value: 3}}}}
+	s, a, err = ca.SQL()
+	assert.NoError(err)
+	assert.Len(a, 3)
+	assert.Equal(`NOT (f1 = ? AND (f2 = ? OR f3 = ?))`, s)
+
+}
+
+func TestCriteriaOps(t *testing.T) {
+
+	assert := assert.New(t)
+
+	var ca Criteria
+	assert.NoError(json.Unmarshal([]byte(`
+[
+	{"field":"f1","op":"isnull"},
+	{"field":"f2","op":"notnull"},
+	{"field":"f3","op":"between","value":[10,20]},
+	{"field":"f4","op":"notin","value":["a","b"]},
+	{"field":"f5","op":"ilike","value":"ab%"},
+	{"field":"f6","op":"startswith","value":"50%_off!"},
+	{"field":"f7","op":"contains","value":"x"}
+]
+`), &ca))
+	s, a, err := ca.SQL()
+	assert.NoError(err)
+	assert.Equal(`f1 IS NULL AND f2 IS NOT NULL AND f3 BETWEEN ? AND ? AND f4 NOT IN ? AND `+
+		`LOWER(f5) LIKE LOWER(?) ESCAPE '!' AND f6 LIKE ? ESCAPE '!' AND f7 LIKE ? ESCAPE '!'`, s)
+	assert.Equal([]interface{}{float64(10), float64(20), []interface{}{"a", "b"}, "ab%", "50!%!_off!!%", "%x%"}, a)
+
+	// dialect specific
+	ca = Criteria{
+		{Field: "f1", Op: ILikeOp, Value: "ab%"},
+		{Field: "f2", Op: FullTextOp, Value: "tacos al pastor"},
+	}
+	s, _, err = ca.SQLDialect(tmeta.PostgresDialect{}, func(n string) string { return `"` + n + `"` })
+	assert.NoError(err)
+	assert.Equal(`"f1" ILIKE ? ESCAPE '!' AND to_tsvector("f2") @@ plainto_tsquery(?)`, s)
+	s, _, err = ca.SQLDialect(tmeta.MySQLDialect{}, func(n string) string { return n })
+	assert.NoError(err)
+	assert.Equal(`LOWER(f1) LIKE LOWER(?) ESCAPE '!' AND MATCH(f2) AGAINST(? IN NATURAL LANGUAGE MODE)`, s)
+	_, _, err = ca.SQL()
+	assert.Error(err)
+
+	// bad values
+	_, _, err = Criteria{{Field: "f1", Op: BetweenOp, Value: []int{1}}}.SQL()
+	assert.Error(err)
+	_, _, err = Criteria{{Field: "f1", Op: StartsWithOp, Value: 1}}.SQL()
+	assert.Error(err)
+
+	// ContainsMatch
+	assert.True(Criteria{{Field: "f1", Op: IsNullOp}}.ContainsMatch(3, "f1"))
+	assert.True(Criteria{{Field: "f1", Op: BetweenOp, Value: []int{1, 2}}}.ContainsMatch(3, "f1"))
+	assert.True(Criteria{{Field: "f1", Op: StartsWithOp, Value: "a%c"}}.ContainsMatch(3, "f1"))
+	assert.False(Criteria{{Field: "f1", Op: StartsWithOp, Value: "ab"}}.ContainsMatch(3, "f1"))
+	assert.False(Criteria{{Field: "f1", Op: ContainsOp, Value: "abcdef"}}.ContainsMatch(3, "f1"))
+	assert.False(Criteria{{Field: "f1", Op: ILikeOp, Value: "abcdef"}}.ContainsMatch(3, "f1"))
+	assert.False(Criteria{{Field: "f1", Op: NotInOp, Value: []string{"a"}}}.ContainsMatch(3, "f1"))
+
 }
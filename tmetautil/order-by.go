@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 // OrderBy corresponds to a single field in an ORDER BY SQL clause including it's direction (ascending by default).
@@ -22,6 +23,19 @@ func (o OrderBy) CheckFieldNames(fields ...string) error {
 	return fmt.Errorf("%q is not a valid field name", o.Field)
 }
 
+// SQL returns the field and direction for use in an ORDER BY clause, e.g. "name DESC".
+func (o OrderBy) SQL() string {
+	return o.SQLQuoted(noQuote)
+}
+
+// SQLQuoted is like SQL but the field name is passed through the quote function provided.
+func (o OrderBy) SQLQuoted(quote func(sqlName string) string) string {
+	if o.Desc {
+		return quote(o.Field) + " DESC"
+	}
+	return quote(o.Field) + " ASC"
+}
+
 // UnmarshalJSON supports normal JSON unmarshaling plus a shorthand of just providing a string to mean the field name sorted ascending.
 func (o *OrderBy) UnmarshalJSON(b []byte) error {
 
@@ -63,6 +77,21 @@ func (ol OrderByList) CheckFieldNames(fields ...string) error {
 	return nil
 }
 
+// SQL returns the list for use in an ORDER BY clause, e.g. "name DESC, created ASC".
+// Empty string is returned for an empty list.
+func (ol OrderByList) SQL() string {
+	return ol.SQLQuoted(noQuote)
+}
+
+// SQLQuoted is like SQL but field names are passed through the quote function provided.
+func (ol OrderByList) SQLQuoted(quote func(sqlName string) string) string {
+	slist := make([]string, 0, len(ol))
+	for _, o := range ol {
+		slist = append(slist, o.SQLQuoted(quote))
+	}
+	return strings.Join(slist, ", ")
+}
+
 // UnmarshalJSON supports normal JSON unmarshaling plus a shorthand of just providing either a single object
 // instead of an array, or a string to mean the field name sorted ascending.
 func (ol *OrderByList) UnmarshalJSON(b []byte) error {
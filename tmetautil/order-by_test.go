@@ -50,4 +50,8 @@ func TestOrderBy(t *testing.T) {
 	assert.NoError(ol.CheckFieldNames("f1", "f2", "f3"))
 	assert.Error(ol.CheckFieldNames("f5", "f2"))
 
+	assert.Equal("f1 DESC, f2 ASC", ol.SQL())
+	assert.Equal("`f1` DESC, `f2` ASC", ol.SQLQuoted(func(n string) string { return "`" + n + "`" }))
+	assert.Equal("", OrderByList{}.SQL())
+
 }
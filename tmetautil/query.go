@@ -0,0 +1,330 @@
+package tmetautil
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gocaveman/tmeta"
+)
+
+// queryOps maps the operator names used in query strings to Ops.
+var queryOps = map[string]Op{
+	"eq":         EqOp,
+	"ne":         NeOp,
+	"lt":         LtOp,
+	"lte":        LteOp,
+	"gt":         GtOp,
+	"gte":        GteOp,
+	"like":       LikeOp,
+	"in":         InOp,
+	"isnull":     IsNullOp,
+	"notnull":    NotNullOp,
+	"between":    BetweenOp,
+	"notin":      NotInOp,
+	"ilike":      ILikeOp,
+	"startswith": StartsWithOp,
+	"contains":   ContainsOp,
+	"fulltext":   FullTextOp,
+}
+
+// QueryParser parses the compact filter and sort syntax used in URL query strings into
+// Criteria and an OrderByList.
+//
+// A filter is a comma separated list of expressions which are ANDed together.  Each expression
+// is "field:op:value", where op is one of eq, ne, lt, lte, gt, gte, like, in, isnull, notnull,
+// between, notin, ilike, startswith, contains or fulltext.  The value is omitted for isnull and
+// notnull, and for in, notin and between it is a list of values separated by "|".
+// An expression can be inverted by prefixing it with "!" and "or(...)" contains a comma separated
+// list of expressions which are ORed together.  A backslash escapes the next character,
+// so values can contain any of ",:|()!\".  Example:
+//
+//	name:like:ab%,or(age:gte:18,guardian_id:notnull),!status:in:banned|deleted
+//
+// A sort is a comma separated list of field names, each prefixed with "-" for descending.
+// Example:
+//
+//	-created,name
+type QueryParser struct {
+	Fields        []string // field names which can be used in filters and sorts
+	MatchFields   []string // if not empty, a filter must match one of these fields, see Criteria.ContainsMatch
+	LikePrefixLen int      // prefix length for LikeOp and StartsWithOp with MatchFields, see Criteria.ContainsMatch
+
+	fieldTypes map[string]reflect.Type
+}
+
+// NewQueryParser returns a QueryParser which allows the SQL fields of the table provided.
+// Filter values are converted to the type of the corresponding struct field where it is
+// numeric or bool, and left as strings otherwise.
+func NewQueryParser(ti *tmeta.TableInfo) *QueryParser {
+	p := &QueryParser{
+		Fields:        ti.SQLFields(true),
+		LikePrefixLen: 3,
+		fieldTypes:    make(map[string]reflect.Type),
+	}
+	for f, v := range ti.SQLValueMap(reflect.New(ti.GoType()).Interface(), true) {
+		p.fieldTypes[f] = reflect.TypeOf(v)
+	}
+	return p
+}
+
+// ParseQuery parses the "filter" and "sort" values from a URL query.
+func (p *QueryParser) ParseQuery(v url.Values) (Criteria, OrderByList, error) {
+	criteria, err := p.ParseFilter(v.Get("filter"))
+	if err != nil {
+		return nil, nil, err
+	}
+	orderBy, err := p.ParseSort(v.Get("sort"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return criteria, orderBy, nil
+}
+
+// ParseSort parses a sort expression, empty string returns an empty list.
+func (p *QueryParser) ParseSort(s string) (OrderByList, error) {
+
+	var ret OrderByList
+	if s == "" {
+		return ret, nil
+	}
+
+	for _, f := range strings.Split(s, ",") {
+		var ob OrderBy
+		if strings.HasPrefix(f, "-") {
+			ob.Desc = true
+			f = f[1:]
+		}
+		ob.Field = f
+		ret = append(ret, ob)
+	}
+
+	err := ret.CheckFieldNames(p.Fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// ParseFilter parses a filter expression, empty string returns empty Criteria
+// (which is an error if MatchFields is set).
+func (p *QueryParser) ParseFilter(s string) (Criteria, error) {
+
+	qs := &queryScanner{s: s}
+	ret, err := p.parseList(qs)
+	if err != nil {
+		return nil, err
+	}
+	if qs.pos < len(qs.s) {
+		return nil, fmt.Errorf("unexpected %q at position %d in filter", qs.s[qs.pos], qs.pos)
+	}
+
+	err = ret.CheckFieldNames(p.Fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.MatchFields) > 0 && !ret.ContainsMatch(p.LikePrefixLen, p.MatchFields...) {
+		return nil, fmt.Errorf("filter must match one of these fields: %s", strings.Join(p.MatchFields, ", "))
+	}
+
+	return ret, nil
+}
+
+// parseList parses comma separated expressions until the end of the input or a ')'
+func (p *QueryParser) parseList(qs *queryScanner) (Criteria, error) {
+
+	var ret Criteria
+	for qs.pos < len(qs.s) && qs.s[qs.pos] != ')' {
+
+		if len(ret) > 0 {
+			if qs.s[qs.pos] != ',' {
+				return nil, fmt.Errorf("expected ',' at position %d in filter", qs.pos)
+			}
+			qs.pos++
+		}
+
+		c, err := p.parseExpr(qs)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+
+	return ret, nil
+}
+
+// parseExpr parses a single expression (possibly an "or(...)")
+func (p *QueryParser) parseExpr(qs *queryScanner) (c Criterion, err error) {
+
+	if strings.HasPrefix(qs.s[qs.pos:], "!") {
+		c.Not = true
+		qs.pos++
+	}
+
+	if strings.HasPrefix(qs.s[qs.pos:], "or(") {
+		qs.pos += len("or(")
+		c.Or, err = p.parseList(qs)
+		if err != nil {
+			return c, err
+		}
+		if qs.pos >= len(qs.s) {
+			return c, fmt.Errorf("missing ')' in filter")
+		}
+		qs.pos++ // ')'
+		if len(c.Or) == 0 {
+			return c, fmt.Errorf("empty or() in filter")
+		}
+		return c, nil
+	}
+
+	start := qs.pos
+	parts := qs.scanParts(":", 3)
+	if len(parts) < 2 {
+		return c, fmt.Errorf("expected field:op:value at position %d in filter", start)
+	}
+	c.Field = parts[0]
+
+	var ok bool
+	c.Op, ok = queryOps[parts[1]]
+	if !ok {
+		return c, fmt.Errorf("unknown operator %q in filter", parts[1])
+	}
+
+	switch c.Op {
+	case IsNullOp, NotNullOp:
+		if len(parts) > 2 {
+			return c, fmt.Errorf("operator %q does not take a value in filter", parts[1])
+		}
+		return c, nil
+	}
+
+	if len(parts) < 3 {
+		return c, fmt.Errorf("operator %q requires a value in filter", parts[1])
+	}
+
+	switch c.Op {
+	case InOp, NotInOp, BetweenOp:
+		vals := splitUnescaped(parts[2], '|')
+		if c.Op == BetweenOp && len(vals) != 2 {
+			return c, fmt.Errorf("operator %q requires two values in filter", parts[1])
+		}
+		list := make([]interface{}, 0, len(vals))
+		for _, v := range vals {
+			cv, err := p.convert(c.Field, unescape(v))
+			if err != nil {
+				return c, err
+			}
+			list = append(list, cv)
+		}
+		c.Value = list
+	case LikeOp, ILikeOp, StartsWithOp, ContainsOp, FullTextOp:
+		c.Value = unescape(parts[2])
+	default:
+		c.Value, err = p.convert(c.Field, unescape(parts[2]))
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// convert returns the value converted to the type of the field, if it's numeric or bool
+func (p *QueryParser) convert(field, v string) (interface{}, error) {
+
+	t := p.fieldTypes[field]
+	if t == nil {
+		return v, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var ret interface{}
+	var err error
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ret, err = strconv.ParseInt(v, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		ret, err = strconv.ParseUint(v, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		ret, err = strconv.ParseFloat(v, 64)
+	case reflect.Bool:
+		ret, err = strconv.ParseBool(v)
+	default:
+		return v, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for field %q: %v", v, field, err)
+	}
+	return ret, nil
+}
+
+// queryScanner keeps track of the position in a filter being parsed
+type queryScanner struct {
+	s   string
+	pos int
+}
+
+// scanParts reads up to the next unescaped ',' or ')' (or the end) and splits the
+// result on unescaped sep into at most n parts, leaving escapes in place
+func (qs *queryScanner) scanParts(sep string, n int) []string {
+
+	start := qs.pos
+	for qs.pos < len(qs.s) {
+		ch := qs.s[qs.pos]
+		if ch == '\\' {
+			qs.pos += 2
+			continue
+		}
+		if ch == ',' || ch == ')' {
+			break
+		}
+		qs.pos++
+	}
+	if qs.pos > len(qs.s) { // trailing backslash
+		qs.pos = len(qs.s)
+	}
+
+	parts := splitUnescaped(qs.s[start:qs.pos], sep[0])
+	if len(parts) > n { // put the extra separators back into the last part
+		parts = append(parts[:n-1], strings.Join(parts[n-1:], sep))
+	}
+	return parts
+}
+
+// splitUnescaped splits s on each sep which is not preceded by a backslash, escapes are left in place
+func splitUnescaped(s string, sep byte) []string {
+	var ret []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(ret, s[start:])
+}
+
+// unescape removes the backslash escapes from s
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,110 @@
+package tmetautil
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/gocaveman/tmeta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryParser(t *testing.T) {
+
+	assert := assert.New(t)
+
+	type Person struct {
+		PersonID   string  `db:"person_id" tmeta:"pk"`
+		Name       string  `db:"name"`
+		Age        int     `db:"age"`
+		Score      float64 `db:"score"`
+		Active     bool    `db:"active"`
+		GuardianID *string `db:"guardian_id"`
+		Created    DBTime  `db:"created"`
+		Secret     string  // not a db field
+	}
+
+	meta := tmeta.NewMeta()
+	assert.NoError(meta.Parse(Person{}))
+	p := NewQueryParser(meta.For(Person{}))
+
+	// simple expressions, values converted by field type
+	ca, err := p.ParseFilter(`name:like:ab%,age:gte:18,score:lt:1.5,active:eq:true`)
+	assert.NoError(err)
+	assert.Equal(Criteria{
+		{Field: "name", Op: LikeOp, Value: "ab%"},
+		{Field: "age", Op: GteOp, Value: int64(18)},
+		{Field: "score", Op: LtOp, Value: 1.5},
+		{Field: "active", Op: EqOp, Value: true},
+	}, ca)
+
+	// or, not, lists and values with colons and escapes
+	ca, err = p.ParseFilter(`or(age:between:13|19,guardian_id:notnull),!name:in:a\,b|c\|d,created:gt:2020-01-01T00:00:00Z`)
+	assert.NoError(err)
+	assert.Equal(Criteria{
+		{Or: Criteria{
+			{Field: "age", Op: BetweenOp, Value: []interface{}{int64(13), int64(19)}},
+			{Field: "guardian_id", Op: NotNullOp},
+		}},
+		{Field: "name", Op: InOp, Not: true, Value: []interface{}{"a,b", "c|d"}},
+		{Field: "created", Op: GtOp, Value: "2020-01-01T00:00:00Z"},
+	}, ca)
+	_, _, err = ca.SQL()
+	assert.NoError(err)
+
+	// negated or applies NOT to the whole group
+	ca, err = p.ParseFilter(`!or(name:eq:a,age:gt:3)`)
+	assert.NoError(err)
+	s, a, err := ca.SQL()
+	assert.NoError(err)
+	assert.Equal(`NOT (name = ? OR age > ?)`, s)
+	assert.Equal([]interface{}{"a", int64(3)}, a)
+
+	// empty
+	ca, err = p.ParseFilter("")
+	assert.NoError(err)
+	assert.Len(ca, 0)
+
+	// errors
+	for _, f := range []string{
+		`secret:eq:x`,      // not allowed
+		`name:bogus:x`,     // unknown op
+		`name`,             // no op
+		`age:eq:abc`,       // bad number
+		`age:between:1`,    // between needs two
+		`name:isnull:x`,    // isnull takes no value
+		`name:eq`,          // missing value
+		`or(name:eq:x`,     // missing )
+		`or()`,             // empty or
+		`name:eq:x)`,       // extra )
+		`or(name:eq:x)abc`, // junk after or
+	} {
+		_, err = p.ParseFilter(f)
+		assert.Error(err, "filter %q", f)
+	}
+
+	// index protection
+	p.MatchFields = []string{"person_id", "name"}
+	_, err = p.ParseFilter(`age:gte:18`)
+	assert.Error(err)
+	_, err = p.ParseFilter(`name:like:a%`)
+	assert.Error(err)
+	_, err = p.ParseFilter(`name:like:abc%,age:gte:18`)
+	assert.NoError(err)
+	p.MatchFields = nil
+
+	// sort
+	ob, err := p.ParseSort("-created,name")
+	assert.NoError(err)
+	assert.Equal(OrderByList{{Field: "created", Desc: true}, {Field: "name"}}, ob)
+	_, err = p.ParseSort("-secret")
+	assert.Error(err)
+
+	// whole query
+	v, err := url.ParseQuery(`filter=name:eq:Joe&sort=-age`)
+	assert.NoError(err)
+	ca, ob, err = p.ParseQuery(v)
+	assert.NoError(err)
+	assert.Equal(Criteria{{Field: "name", Op: EqOp, Value: "Joe"}}, ca)
+	assert.Equal(OrderByList{{Field: "age", Desc: true}}, ob)
+
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 	"reflect"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -36,57 +35,6 @@ func elemDerefType(t reflect.Type) reflect.Type {
 	return t
 }
 
-type sqlFieldIndexCacheKey struct {
-	T reflect.Type
-	F string
-}
-
-var sqlFieldIndexCacheMU sync.RWMutex
-var sqlFieldIndexCache = make(map[sqlFieldIndexCacheKey][]int)
-
-func sqlFieldIndex(t reflect.Type, sqlFieldName string) []int {
-
-	sqlFieldIndexCacheMU.RLock()
-	ret, ok := sqlFieldIndexCache[sqlFieldIndexCacheKey{T: t, F: sqlFieldName}]
-	sqlFieldIndexCacheMU.RUnlock()
-
-	if ok {
-		return ret
-	}
-
-	sqlFieldIndexCacheMU.Lock()
-	defer sqlFieldIndexCacheMU.Unlock()
-
-	for _, idx := range exportedFieldIndexes(t) {
-		sf := t.FieldByIndex(idx)
-		sfdb := strings.SplitN(sf.Tag.Get("db"), ",", 2)[0]
-		if sfdb == "" || sfdb == "-" {
-			continue
-		}
-		if sfdb == sqlFieldName {
-			ret = idx
-			break
-		}
-	}
-
-	// write result to cache (might be nil, but that's okay to cache also)
-	sqlFieldIndexCache[sqlFieldIndexCacheKey{T: t, F: sqlFieldName}] = ret
-
-	return ret
-}
-
-func sqlFieldValue(v reflect.Value, sqlFieldName string) interface{} {
-
-	t := v.Type()
-	idx := sqlFieldIndex(t, sqlFieldName)
-	if idx == nil {
-		return nil
-	}
-
-	f := v.FieldByIndex(idx)
-	return f.Interface()
-}
-
 // var ErrNoField = fmt.Errorf("field not found")
 // var ErrNoTable = fmt.Errorf("table not found for object/type")
 
@@ -280,7 +228,7 @@ func structTagToValues(st string) url.Values {
 
 	ret := make(url.Values)
 
-	parts := strings.Split(st, ",")
+	parts := splitTag(st)
 
 	for _, part := range parts {
 		kvparts := strings.SplitN(part, "=", 2)
@@ -294,6 +242,29 @@ func structTagToValues(st string) url.Values {
 	return ret
 }
 
+// splitTag splits a struct tag on commas, except for commas inside (), [] or {}, so
+// values like "sql_type=DECIMAL(10,2)" and "pattern=^[a-z]{2,5}$" are kept intact.
+func splitTag(st string) []string {
+	var ret []string
+	depth, start := 0, 0
+	for i := 0; i < len(st); i++ {
+		switch st[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				ret = append(ret, st[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, st[start:])
+}
+
 // printEventReceiver writes to anything that implements printer.
 // For example a *log.Logger
 type printEventReceiver struct {
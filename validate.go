@@ -0,0 +1,248 @@
+package tmeta
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldValidation describes the checks made on one field by TableInfo.Validate, normally
+// set from the `required`, `max_len=`, `min=`, `max=`, `enum=` and `pattern=` tmeta struct tags.
+// Except for Required, checks are skipped on nil pointers, and Enum and Pattern are skipped on
+// zero values so optional fields can be left empty.
+type FieldValidation struct {
+	SQLName  string         // SQL field name, used as the key in ValidationErrors
+	GoName   string         // Go struct field name
+	Required bool           // must not be the zero value (or a nil pointer)
+	MaxLen   int            // maximum length in characters of a string, 0 means no limit
+	Min      *float64       // minimum value of a number, nil means no limit
+	Max      *float64       // maximum value of a number, nil means no limit
+	Enum     []string       // allowed values of a string or integer, empty means any
+	Pattern  *regexp.Regexp // a string must match this, not anchored unless the expression is
+}
+
+// FieldError is the reason a single field failed validation.
+type FieldError struct {
+	SQLName string // SQL field name
+	Message string // e.g. "is required"
+}
+
+func (e *FieldError) Error() string { return e.SQLName + " " + e.Message }
+
+// ValidationErrors is returned by TableInfo.Validate, with one FieldError for each invalid
+// field in the order the fields appear in the struct.
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "tmeta: validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Code returns 400.
+func (e ValidationErrors) Code() int { return 400 }
+
+// Map returns the messages keyed by SQL field name.
+func (e ValidationErrors) Map() map[string]string {
+	ret := make(map[string]string, len(e))
+	for _, fe := range e {
+		ret[fe.SQLName] = fe.Message
+	}
+	return ret
+}
+
+// SetFieldValidation sets the validation for the field named by fv.SQLName, replacing any
+// existing one.
+func (ti *TableInfo) SetFieldValidation(fv *FieldValidation) *TableInfo {
+	for i, v := range ti.validations {
+		if v.SQLName == fv.SQLName {
+			ti.validations[i] = fv
+			return ti
+		}
+	}
+	ti.validations = append(ti.validations, fv)
+	return ti
+}
+
+// FieldValidations returns the validations for this table, in field order.
+func (ti *TableInfo) FieldValidations() []*FieldValidation {
+	return ti.validations
+}
+
+// Validate checks o (a struct or pointer to one of the type this TableInfo describes) against
+// the FieldValidations and returns ValidationErrors if any field is invalid, or nil if all are valid.
+func (ti *TableInfo) Validate(o interface{}) error {
+
+	if len(ti.validations) == 0 {
+		return nil
+	}
+
+	v := derefValue(reflect.ValueOf(o))
+	if v.Type() != ti.goType {
+		return fmt.Errorf("cannot validate %T using TableInfo for %v", o, ti.goType)
+	}
+
+	var ret ValidationErrors
+	for _, fv := range ti.validations {
+		var fieldv reflect.Value
+		if fi := ti.FieldByGoName(fv.GoName); fi != nil {
+			fieldv = fieldByIndex(v, fi.Index)
+		} else {
+			fieldv = v.FieldByName(fv.GoName)
+		}
+		if msg := fv.check(fieldv); msg != "" {
+			ret = append(ret, &FieldError{SQLName: fv.SQLName, Message: msg})
+		}
+	}
+
+	if len(ret) > 0 {
+		return ret
+	}
+	return nil
+}
+
+// check returns the message for the first check v fails, or empty string if it passes
+func (fv *FieldValidation) check(v reflect.Value) string {
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if fv.Required {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	isZero := v.IsZero()
+	if fv.Required && isZero {
+		return "is required"
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		if fv.MaxLen > 0 && utf8.RuneCountInString(s) > fv.MaxLen {
+			return fmt.Sprintf("must be at most %d characters", fv.MaxLen)
+		}
+		if fv.Pattern != nil && !isZero && !fv.Pattern.MatchString(s) {
+			return fmt.Sprintf("must match the pattern %s", fv.Pattern)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if msg := fv.checkRange(float64(v.Int())); msg != "" {
+			return msg
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if msg := fv.checkRange(float64(v.Uint())); msg != "" {
+			return msg
+		}
+	case reflect.Float32, reflect.Float64:
+		if msg := fv.checkRange(v.Float()); msg != "" {
+			return msg
+		}
+	}
+
+	if len(fv.Enum) > 0 && !isZero {
+		s := fmt.Sprint(v.Interface())
+		for _, e := range fv.Enum {
+			if e == s {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(fv.Enum, ", ")
+	}
+
+	return ""
+}
+
+func (fv *FieldValidation) checkRange(f float64) string {
+	if fv.Min != nil && f < *fv.Min {
+		return "must be at least " + strconv.FormatFloat(*fv.Min, 'f', -1, 64)
+	}
+	if fv.Max != nil && f > *fv.Max {
+		return "must be at most " + strconv.FormatFloat(*fv.Max, 'f', -1, 64)
+	}
+	return ""
+}
+
+// parseFieldValidation returns the FieldValidation from the tag values for the field f of t,
+// or nil if it has none.  Options which don't make sense for the type of f are an error.
+func parseFieldValidation(t reflect.Type, f reflect.StructField, sqlName string, tagv url.Values) (*FieldValidation, error) {
+
+	fv := FieldValidation{
+		SQLName:  sqlName,
+		GoName:   f.Name,
+		Required: len(tagv["required"]) > 0,
+	}
+	hasRule := fv.Required
+
+	kind := derefType(f.Type).Kind()
+	isString := kind == reflect.String
+	var isInt, isNumber bool
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		isInt, isNumber = true, true
+	case reflect.Float32, reflect.Float64:
+		isNumber = true
+	}
+
+	if len(tagv["max_len"]) > 0 {
+		if !isString {
+			return nil, fmt.Errorf("max_len field %q on type %v requires a string, not %v", f.Name, t, f.Type)
+		}
+		n, err := strconv.Atoi(tagv.Get("max_len"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("max_len field %q on type %v must be a positive integer, not %q", f.Name, t, tagv.Get("max_len"))
+		}
+		fv.MaxLen, hasRule = n, true
+	}
+
+	for _, opt := range []string{"min", "max"} {
+		if len(tagv[opt]) == 0 {
+			continue
+		}
+		if !isNumber {
+			return nil, fmt.Errorf("%s field %q on type %v requires a number, not %v", opt, f.Name, t, f.Type)
+		}
+		n, err := strconv.ParseFloat(tagv.Get(opt), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s field %q on type %v must be a number, not %q", opt, f.Name, t, tagv.Get(opt))
+		}
+		if opt == "min" {
+			fv.Min = &n
+		} else {
+			fv.Max = &n
+		}
+		hasRule = true
+	}
+
+	if len(tagv["enum"]) > 0 {
+		if !isString && !isInt {
+			return nil, fmt.Errorf("enum field %q on type %v requires a string or integer, not %v", f.Name, t, f.Type)
+		}
+		fv.Enum, hasRule = strings.Split(tagv.Get("enum"), "|"), true
+	}
+
+	if len(tagv["pattern"]) > 0 {
+		if !isString {
+			return nil, fmt.Errorf("pattern field %q on type %v requires a string, not %v", f.Name, t, f.Type)
+		}
+		re, err := regexp.Compile(tagv.Get("pattern"))
+		if err != nil {
+			return nil, fmt.Errorf("pattern field %q on type %v is invalid: %v", f.Name, t, err)
+		}
+		fv.Pattern, hasRule = re, true
+	}
+
+	if !hasRule {
+		return nil, nil
+	}
+	return &fv, nil
+}